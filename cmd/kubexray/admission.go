@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admission_v1 "k8s.io/api/admission/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// the certificate and key serving the admission webhook, mounted from a secret
+	tlsCertPath = "/config/tls/tls.crt"
+	tlsKeyPath  = "/config/tls/tls.key"
+	// the address the admission webhook listens on
+	admissionAddr = ":8443"
+	// the timeout of the admission webhooks by default, as in the api server
+	defaultAdmissionTimeout = 10
+	// the time left to the api server to receive a response before the
+	// webhook times out
+	admissionMargin = 2 * time.Second
+)
+
+// AdmissionPolicy encodes the admission section of the config.yaml file.
+type AdmissionPolicy struct {
+	// deny pods whose images cannot be checked with xray, rather than
+	// admitting them unchecked
+	FailClosed bool `yaml:"failClosed"`
+	// the timeoutSeconds of the admission webhooks, which checking a pod
+	// must not exceed for failClosed to be honoured
+	TimeoutSeconds int `yaml:"timeoutSeconds"`
+}
+
+// get how long checking a pod may take, finishing before the api server
+// gives up on the webhook and applies its failure policy instead
+func (a AdmissionPolicy) deadline() time.Duration {
+	secs := a.TimeoutSeconds
+	if secs <= 0 {
+		secs = defaultAdmissionTimeout
+	}
+	timeout := time.Duration(secs) * time.Second
+	if timeout > 2*admissionMargin {
+		return timeout - admissionMargin
+	}
+	return timeout / 2
+}
+
+// check whether an action keeps a workload from running, and so should keep
+// its pods from being admitted in the first place; the other actions leave
+// it running and are taken once the pod is running
+func (a Action) blocksAdmission() bool {
+	switch a {
+	case Delete, Scaledown, Suspend, Evict:
+		return true
+	}
+	return false
+}
+
+// get the digest an image reference is pinned to, if any
+func imageDigest(image string) string {
+	idx := strings.LastIndex(image, "@sha256:")
+	if idx == -1 {
+		return ""
+	}
+	return image[idx+8:]
+}
+
+// check a pod about to be created against xray, returning the reason to deny
+// it if the policies do not allow it to run
+func (t *HandlerImpl) admitPod(ctx context.Context, client kubernetes.Interface, pod *core_v1.Pod) (bool, string) {
+	owner := checkResource(client, pod)
+	pols := applyOverrides(client, t.policiesFor(client, pod.Namespace), pod, owner)
+	scan := PodScan{Results: make([]ScanResult, 0)}
+	imgs := podImages(ctx, client, pod)
+	batch := t.prefetchDigests(ctx, t.imageDigests(imgs))
+	for _, img := range imgs {
+		res, err := t.scanImage(ctx, pols, batch, pod.Namespace, img.Image, img.Digest)
+		if err != nil {
+			if t.admission.FailClosed {
+				return false, "kubexray cannot check image " + img.Image + " with Xray: " + err.Error()
+			}
+			log.Warnf("Admitting image %s unchecked: %s", img.Image, err)
+			continue
+		}
+		if res == nil && img.Digest == "" && t.url != "" && !t.images.allowed(img.Image) {
+			// without a digest, xray cannot vouch for the image
+			res = &ScanResult{Image: img.Image}
+		}
+		if res != nil {
+			res.Role = img.Role
+			if res = pols.filterRole(*res); res != nil {
+				scan.Results = append(scan.Results, *res)
+			}
+		}
+	}
+	rec := scan.recognized()
+	seciss, liciss := scan.flags()
+	if isWhitelistedNamespace(pols, pod.Namespace, rec, seciss, liciss) {
+		return true, ""
+	}
+	action := t.decide(client, pols, pod, owner, scan)
+	if !action.blocksAdmission() {
+		return true, ""
+	}
+	msg := "kubexray policy requires to " + action.String() + " " + owner.Type.String() + " " + owner.Name + ":"
+	for _, res := range scan.Results {
+		if !res.Recognized {
+			msg += " " + res.Image + " is unrecognized by Xray;"
+		} else if len(res.Issues) > 0 {
+			msg += " " + res.Image + " has " + describeIssues(res.Issues) + ";"
+		}
+	}
+	msg = strings.TrimSuffix(msg, ";")
+	if t.dryRun {
+		log.Infof("Dry run: would deny pod %s in %s, %s", pod.GenerateName+pod.Name, pod.Namespace, msg)
+		return true, ""
+	}
+	log.Infof("Denying pod %s in %s, %s", pod.GenerateName+pod.Name, pod.Namespace, msg)
+	return false, msg
+}
+
+// patchOperation is an operation of a json patch.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// get the json patch pinning the images of a pod to the digests their tags
+// currently point to, leaving those that cannot be resolved as they are
+func pinImages(ctx context.Context, client kubernetes.Interface, pod *core_v1.Pod) []patchOperation {
+	creds := registryCredentials(ctx, client, pod)
+	patch := make([]patchOperation, 0)
+	pin := func(field string, containers []core_v1.Container) {
+		for i, container := range containers {
+			if imageDigest(container.Image) != "" {
+				continue
+			}
+			digest, err := resolveDigest(ctx, container.Image, creds)
+			if err != nil {
+				log.Warnf("Cannot pin image %s to its digest: %s", container.Image, err)
+				continue
+			}
+			log.Debugf("Pinning image %s to %s", container.Image, digest)
+			path := "/spec/" + field + "/" + strconv.Itoa(i) + "/image"
+			patch = append(patch, patchOperation{Op: "replace", Path: path, Value: container.Image + "@" + digest})
+		}
+	}
+	pin("initContainers", pod.Spec.InitContainers)
+	pin("containers", pod.Spec.Containers)
+	return patch
+}
+
+// handle an admission review of a pod from the api server, filling in the
+// response with the given function
+func handleReview(review func(context.Context, *core_v1.Pod, *admission_v1.AdmissionResponse)) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		var data admission_v1.AdmissionReview
+		err := json.NewDecoder(req.Body).Decode(&data)
+		if err != nil || data.Request == nil {
+			log.Errorf("Error reading admission request: %v", err)
+			resp.WriteHeader(400)
+			return
+		}
+		response := &admission_v1.AdmissionResponse{UID: data.Request.UID, Allowed: true}
+		if data.Request.Kind.Kind == "Pod" {
+			var pod core_v1.Pod
+			err = json.Unmarshal(data.Request.Object.Raw, &pod)
+			if err != nil {
+				log.Errorf("Error reading admission request: %v", err)
+				resp.WriteHeader(400)
+				return
+			}
+			if pod.Namespace == "" {
+				pod.Namespace = data.Request.Namespace
+			}
+			review(req.Context(), &pod, response)
+		}
+		// answer in the version the api server asked in, which v1 decodes
+		// as the two versions share their fields
+		if data.APIVersion == "" {
+			data.APIVersion = admission_v1.SchemeGroupVersion.String()
+		}
+		data.Kind = "AdmissionReview"
+		data.Request = nil
+		data.Response = response
+		body, err := json.Marshal(data)
+		if err != nil {
+			log.Errorf("Error writing admission response: %v", err)
+			resp.WriteHeader(500)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write(body)
+	}
+}
+
+// handle when the api server asks whether to admit a pod
+func handleValidate(t *HandlerImpl, client kubernetes.Interface) http.HandlerFunc {
+	return handleReview(func(ctx context.Context, pod *core_v1.Pod, response *admission_v1.AdmissionResponse) {
+		conf := t.snapshot()
+		ctx, cancel := context.WithTimeout(ctx, conf.admission.deadline())
+		defer cancel()
+		allowed, msg := conf.admitPod(ctx, client, pod)
+		if !allowed {
+			response.Allowed = false
+			response.Result = &meta_v1.Status{Message: msg, Reason: meta_v1.StatusReasonForbidden, Code: 403}
+		}
+	})
+}
+
+// handle when the api server asks to mutate a pod, pinning its images
+func handleMutate(t *HandlerImpl, client kubernetes.Interface) http.HandlerFunc {
+	return handleReview(func(ctx context.Context, pod *core_v1.Pod, response *admission_v1.AdmissionResponse) {
+		ctx, cancel := context.WithTimeout(ctx, t.snapshot().admission.deadline())
+		defer cancel()
+		patch := pinImages(ctx, client, pod)
+		if len(patch) == 0 {
+			return
+		}
+		body, err := json.Marshal(patch)
+		if err != nil {
+			log.Errorf("Error writing admission patch: %v", err)
+			return
+		}
+		pt := admission_v1.PatchTypeJSONPatch
+		response.Patch = body
+		response.PatchType = &pt
+	})
+}
+
+// serve the admission webhook, if a certificate is provided for it
+func setupAdmissionWebhook(t *HandlerImpl, client kubernetes.Interface) {
+	if _, err := os.Stat(tlsCertPath); err != nil {
+		log.Debug("No certificate provided, not serving the admission webhook")
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", handleValidate(t, client))
+	mux.HandleFunc("/mutate", handleMutate(t, client))
+	go func() {
+		err := http.ListenAndServeTLS(admissionAddr, tlsCertPath, tlsKeyPath, mux)
+		if err != nil {
+			log.Errorf("Error running admission webhook: %v", err)
+		}
+	}()
+}
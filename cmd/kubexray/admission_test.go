@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfrog/kubexray/xray"
+	admission_v1 "k8s.io/api/admission/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testDigest = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// a bare pod running the given images
+func testPod(images ...string) core_v1.Pod {
+	pod := core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{GenerateName: "debug-"}}
+	for _, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, core_v1.Container{Name: "app", Image: image})
+	}
+	return pod
+}
+
+// an admission review asking to create the given pod
+func admissionReview(t *testing.T, kind string, pod core_v1.Pod) []byte {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := admission_v1.AdmissionReview{
+		TypeMeta: meta_v1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admission_v1.AdmissionRequest{
+			UID:       "req-uid",
+			Kind:      meta_v1.GroupVersionKind{Version: "v1", Kind: kind},
+			Namespace: "default",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestHandleValidate(t *testing.T) {
+	// an xray server that is down
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	images := ImagePolicy{Allow: []string{"gcr.io/distroless/*"}, Deny: []string{"*:latest"}}
+	if err := images.init(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		kind        string
+		images      []string
+		pods        Action
+		failClosed  bool
+		dryRun      bool
+		wantAllowed bool
+	}{
+		{"allowed image", "Pod", []string{"gcr.io/distroless/base:nonroot"}, Evict, false, false, true},
+		{"denied image", "Pod", []string{"nginx:latest"}, Evict, false, false, false},
+		{"denied image, dry run", "Pod", []string{"nginx:latest"}, Evict, false, true, true},
+		{"denied image, label only", "Pod", []string{"nginx:latest"}, Label, false, false, true},
+		{"unpinned image", "Pod", []string{"nginx:1.17"}, Evict, false, false, false},
+		{"xray down, fail open", "Pod", []string{"nginx@sha256:" + testDigest}, Evict, false, false, true},
+		{"xray down, fail closed", "Pod", []string{"nginx@sha256:" + testDigest}, Evict, true, false, false},
+		{"not a pod", "Service", []string{"nginx:latest"}, Evict, false, false, true},
+	}
+	for _, tt := range tests {
+		handler := &HandlerImpl{
+			url:       down.URL,
+			xray:      xray.NewClient(down.URL, "", "", xray.Options{}),
+			cache:     newScanCache(CacheOptions{}),
+			unscanned: Policy{pods: tt.pods},
+			security:  Policy{pods: tt.pods},
+			license:   Policy{pods: tt.pods},
+			images:    images,
+			admission: AdmissionPolicy{FailClosed: tt.failClosed},
+			dryRun:    tt.dryRun,
+		}
+		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(admissionReview(t, tt.kind, testPod(tt.images...))))
+		resp := httptest.NewRecorder()
+		handleValidate(handler, fake.NewSimpleClientset())(resp, req)
+		if resp.Code != 200 {
+			t.Errorf("%s: status %d", tt.name, resp.Code)
+			continue
+		}
+		var review admission_v1.AdmissionReview
+		if err := json.NewDecoder(resp.Body).Decode(&review); err != nil || review.Response == nil {
+			t.Errorf("%s: cannot read response: %v", tt.name, err)
+			continue
+		}
+		if review.Response.UID != "req-uid" {
+			t.Errorf("%s: response uid %q", tt.name, review.Response.UID)
+		}
+		if review.Response.Allowed != tt.wantAllowed {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, review.Response.Allowed, tt.wantAllowed)
+		}
+		if !tt.wantAllowed && (review.Response.Result == nil || !strings.HasPrefix(review.Response.Result.Message, "kubexray ")) {
+			t.Errorf("%s: denied without a reason: %+v", tt.name, review.Response.Result)
+		}
+	}
+}
+
+func TestHandleMutate(t *testing.T) {
+	host := testRegistry(t)
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:s3cret"))
+	client := fake.NewSimpleClientset(pullSecret("registry", string(core_v1.SecretTypeDockerConfigJson), map[string]interface{}{
+		"auths": map[string]RegistryAuth{host: {Auth: auth}},
+	}))
+	pod := testPod(host+"/team/app:v1", "nginx@sha256:"+testDigest, host+"/team/app:v2")
+	pod.Spec.InitContainers = []core_v1.Container{{Name: "init", Image: host + "/team/app:v1"}}
+	pod.Spec.ImagePullSecrets = []core_v1.LocalObjectReference{{Name: "registry"}}
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(admissionReview(t, "Pod", pod)))
+	resp := httptest.NewRecorder()
+	handleMutate(&HandlerImpl{}, client)(resp, req)
+	var review admission_v1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil || review.Response == nil {
+		t.Fatalf("cannot read response: %v", err)
+	}
+	if !review.Response.Allowed || review.Response.PatchType == nil || *review.Response.PatchType != admission_v1.PatchTypeJSONPatch {
+		t.Fatalf("response = %+v, want an allowed json patch", review.Response)
+	}
+	var patch []patchOperation
+	if err := json.Unmarshal(review.Response.Patch, &patch); err != nil {
+		t.Fatalf("cannot read patch: %v", err)
+	}
+	// the pinned image and the unknown tag are left alone
+	pinned := host + "/team/app:v1@sha256:" + testDigest
+	want := []patchOperation{
+		{Op: "replace", Path: "/spec/initContainers/0/image", Value: pinned},
+		{Op: "replace", Path: "/spec/containers/0/image", Value: pinned},
+	}
+	if len(patch) != len(want) {
+		t.Fatalf("patch = %+v, want %+v", patch, want)
+	}
+	for i := range want {
+		if patch[i] != want[i] {
+			t.Errorf("patch[%d] = %+v, want %+v", i, patch[i], want[i])
+		}
+	}
+}
+
+func TestHandleReviewMalformed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(`{"request":`))
+	resp := httptest.NewRecorder()
+	handleValidate(&HandlerImpl{}, fake.NewSimpleClientset())(resp, req)
+	if resp.Code != 400 {
+		t.Errorf("status %d, want 400", resp.Code)
+	}
+}
+
+func TestHandleReviewVersion(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		want       string
+	}{
+		{"admission.k8s.io/v1", "admission.k8s.io/v1"},
+		{"admission.k8s.io/v1beta1", "admission.k8s.io/v1beta1"},
+		// reviews without a version are answered as v1
+		{"", "admission.k8s.io/v1"},
+	}
+	for _, tt := range tests {
+		var review map[string]interface{}
+		if err := json.Unmarshal(admissionReview(t, "Pod", testPod("gcr.io/distroless/base:nonroot")), &review); err != nil {
+			t.Fatal(err)
+		}
+		review["apiVersion"] = tt.apiVersion
+		body, err := json.Marshal(review)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+		resp := httptest.NewRecorder()
+		handleMutate(&HandlerImpl{}, fake.NewSimpleClientset())(resp, req)
+		var got admission_v1.AdmissionReview
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || got.Response == nil {
+			t.Errorf("%q: cannot read response: %v", tt.apiVersion, err)
+			continue
+		}
+		if got.APIVersion != tt.want || got.Kind != "AdmissionReview" {
+			t.Errorf("%q: answered as %s %s, want %s AdmissionReview", tt.apiVersion, got.APIVersion, got.Kind, tt.want)
+		}
+		if got.Request != nil {
+			t.Errorf("%q: response echoes the request", tt.apiVersion)
+		}
+	}
+}
+
+func TestAdmissionDeadline(t *testing.T) {
+	tests := []struct {
+		timeout int
+		want    time.Duration
+	}{
+		{0, 8 * time.Second},
+		{30, 28 * time.Second},
+		{5, 3 * time.Second},
+		{3, 1500 * time.Millisecond},
+		{1, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := (AdmissionPolicy{TimeoutSeconds: tt.timeout}).deadline(); got != tt.want {
+			t.Errorf("deadline with timeoutSeconds %d = %s, want %s", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestHandleValidateTimeout(t *testing.T) {
+	// an xray that does not answer before the webhook times out
+	stalled := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer stalled.Close()
+	handler := &HandlerImpl{
+		url:       stalled.URL,
+		xray:      xray.NewClient(stalled.URL, "", "", xray.Options{}),
+		cache:     newScanCache(CacheOptions{}),
+		security:  Policy{pods: Evict},
+		admission: AdmissionPolicy{FailClosed: true, TimeoutSeconds: 1},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(admissionReview(t, "Pod", testPod("nginx@sha256:"+testDigest))))
+	resp := httptest.NewRecorder()
+	start := time.Now()
+	handleValidate(handler, fake.NewSimpleClientset())(resp, req)
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("responded after %s, past the webhook timeout", elapsed)
+	}
+	var review admission_v1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil || review.Response == nil {
+		t.Fatalf("cannot read response: %v", err)
+	}
+	if review.Response.Allowed {
+		t.Error("admitted a pod xray could not check in time")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// how long xray results are reused by default
+	defaultCacheTTL = 10 * time.Minute
+	// how long the results for images xray does not recognize are reused by
+	// default, shorter since they change once the image is indexed
+	defaultUnrecognizedTTL = time.Minute
+	// how often the cache is written to its file, if persisted
+	cacheFlushInterval = time.Minute
+)
+
+// CacheOptions are the settings of the scan cache, from xray_config.yaml.
+type CacheOptions struct {
+	TTL             time.Duration
+	UnrecognizedTTL time.Duration
+	File            string
+}
+
+// cacheEntry is a cached scan result, as persisted to the cache file.
+type cacheEntry struct {
+	Result  ScanResult `json:"result"`
+	Expires time.Time  `json:"expires"`
+}
+
+// cacheCall is a lookup in progress, which concurrent lookups of the same
+// digest wait for instead of asking xray again.
+type cacheCall struct {
+	done chan struct{}
+	res  ScanResult
+	err  error
+	// the number of concurrent lookups waiting for this one, and whether its
+	// digest was invalidated meanwhile so its result must not be cached;
+	// both guarded by the cache's lock
+	waiters     int
+	invalidated bool
+}
+
+// ScanCache caches the results of checking images with xray, keyed by digest,
+// so that the replicas of a workload do not each ask xray about the same image.
+type ScanCache struct {
+	opts    CacheOptions
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	calls   map[string]*cacheCall
+	dirty   bool
+}
+
+// create a scan cache, loading the entries persisted to its file, if any
+func newScanCache(opts CacheOptions) *ScanCache {
+	c := &ScanCache{opts: opts, entries: make(map[string]cacheEntry), calls: make(map[string]*cacheCall)}
+	if opts.File == "" {
+		return c
+	}
+	file, err := ioutil.ReadFile(opts.File)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Cannot read scan cache %s: %s", opts.File, err)
+		}
+		return c
+	}
+	var entries map[string]cacheEntry
+	err = json.Unmarshal(file, &entries)
+	if err != nil {
+		log.Warnf("Cannot read scan cache %s: %s", opts.File, err)
+		return c
+	}
+	now := time.Now()
+	for sha2, entry := range entries {
+		if entry.Expires.After(now) {
+			c.entries[sha2] = entry
+		}
+	}
+	log.Debugf("Loaded %d scan results from %s", len(c.entries), opts.File)
+	return c
+}
+
+// get the xray result for a digest, from the cache if it is still fresh and
+// otherwise with the given lookup, which runs once for concurrent callers;
+// failed lookups are not cached
+func (c *ScanCache) get(ctx context.Context, sha2 string, lookup func() (ScanResult, error)) (ScanResult, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[sha2]; ok {
+		if time.Now().Before(entry.Expires) {
+			c.mu.Unlock()
+			log.Debugf("Using cached xray result for sha %s", sha2)
+			return entry.Result, nil
+		}
+		delete(c.entries, sha2)
+	}
+	if call, ok := c.calls[sha2]; ok {
+		call.waiters++
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.res, call.err
+		case <-ctx.Done():
+			return ScanResult{}, ctx.Err()
+		}
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[sha2] = call
+	c.mu.Unlock()
+
+	call.res, call.err = lookup()
+	c.mu.Lock()
+	c.finish(sha2, call)
+	c.mu.Unlock()
+	close(call.done)
+	return call.res, call.err
+}
+
+// look up the digests that are neither cached nor already being looked up in
+// a single batch, returning the outcome for each of them, so that checking
+// them needs no further lookups even if the cache is disabled; a failed
+// batch is the outcome of each of its digests, rather than a reason to look
+// them up one by one
+func (c *ScanCache) prefetch(shas []string, lookup func([]string) (map[string]ScanResult, error)) map[string]*cacheCall {
+	now := time.Now()
+	missing := make([]string, 0)
+	calls := make(map[string]*cacheCall)
+	c.mu.Lock()
+	for _, sha2 := range shas {
+		if entry, ok := c.entries[sha2]; ok && now.Before(entry.Expires) {
+			continue
+		}
+		if _, ok := c.calls[sha2]; ok {
+			continue
+		}
+		call := &cacheCall{done: make(chan struct{})}
+		c.calls[sha2] = call
+		calls[sha2] = call
+		missing = append(missing, sha2)
+	}
+	c.mu.Unlock()
+	if len(missing) == 0 {
+		return calls
+	}
+	results, err := lookup(missing)
+	if err != nil {
+		log.Warnf("Cannot look up %d digests with xray at once: %s", len(missing), err)
+	}
+	c.mu.Lock()
+	for sha2, call := range calls {
+		call.err = err
+		if err == nil {
+			res, ok := results[sha2]
+			if !ok {
+				res = ScanResult{Checksum: sha2}
+			}
+			call.res = res
+		}
+		c.finish(sha2, call)
+	}
+	c.mu.Unlock()
+	for _, call := range calls {
+		close(call.done)
+	}
+	return calls
+}
+
+// record the outcome of a lookup, caching it unless it failed or its digest
+// was invalidated while it was in progress; the caller must hold the lock
+func (c *ScanCache) finish(sha2 string, call *cacheCall) {
+	if c.calls[sha2] == call {
+		delete(c.calls, sha2)
+	}
+	if call.invalidated {
+		return
+	}
+	// a zero ttl disables the cache, and a zero unrecognized ttl only the
+	// negative caching
+	ttl := c.opts.TTL
+	if !call.res.Recognized && ttl > 0 {
+		ttl = c.opts.UnrecognizedTTL
+	}
+	if call.err == nil && ttl > 0 {
+		c.entries[sha2] = cacheEntry{Result: call.res, Expires: time.Now().Add(ttl)}
+		c.dirty = true
+	}
+}
+
+// drop the cached result for a digest, such as when xray reports a new issue
+// for it; a lookup in progress may predate the report, so its result is not
+// cached and later lookups do not wait for it
+func (c *ScanCache) invalidate(sha2 string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[sha2]; ok {
+		call.invalidated = true
+		delete(c.calls, sha2)
+	}
+	if _, ok := c.entries[sha2]; ok {
+		log.Debugf("Dropping cached xray result for sha %s", sha2)
+		delete(c.entries, sha2)
+		c.dirty = true
+	}
+}
+
+// write the cache to its file, if persisted and changed since last written
+func (c *ScanCache) flush() {
+	c.mu.Lock()
+	if c.opts.File == "" || !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	entries := make(map[string]cacheEntry)
+	for sha2, entry := range c.entries {
+		if entry.Expires.After(now) {
+			entries[sha2] = entry
+		}
+	}
+	c.dirty = false
+	c.mu.Unlock()
+	data, err := json.Marshal(entries)
+	if err == nil {
+		// write a copy first, so a crash cannot leave a truncated cache behind
+		err = ioutil.WriteFile(c.opts.File+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(c.opts.File+".tmp", c.opts.File)
+	}
+	if err != nil {
+		log.Warnf("Cannot write scan cache %s: %s", c.opts.File, err)
+	}
+}
+
+// periodically write the scan cache to its file, if persisted
+func setupCache(t *HandlerImpl) {
+	go func() {
+		for range time.Tick(cacheFlushInterval) {
+			t.mu.RLock()
+			cache := t.cache
+			t.mu.RUnlock()
+			cache.flush()
+		}
+	}()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScanCacheSingleFlight(t *testing.T) {
+	cache := newScanCache(CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute})
+	var lookups int32
+	release := make(chan struct{})
+	lookup := func() (ScanResult, error) {
+		atomic.AddInt32(&lookups, 1)
+		<-release
+		return ScanResult{Checksum: "abc", Recognized: true}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := cache.get(context.Background(), "abc", lookup)
+			if err != nil || !res.Recognized {
+				t.Errorf("get = %+v, %v", res, err)
+			}
+		}()
+	}
+	// let the other callers pile up behind the first lookup
+	for waiters(cache, "abc") < 9 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+	if lookups != 1 {
+		t.Errorf("looked up %d times, want 1", lookups)
+	}
+}
+
+// the number of lookups waiting for the one in progress for a digest
+func waiters(cache *ScanCache, sha2 string) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if call, ok := cache.calls[sha2]; ok {
+		return call.waiters
+	}
+	return 0
+}
+
+func TestScanCacheInvalidateInFlight(t *testing.T) {
+	cache := newScanCache(CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	lookups := 0
+	stale := func() (ScanResult, error) {
+		lookups++
+		close(started)
+		<-release
+		return ScanResult{Checksum: "abc"}, nil
+	}
+	done := make(chan ScanResult)
+	go func() {
+		res, _ := cache.get(context.Background(), "abc", stale)
+		done <- res
+	}()
+	<-started
+	// xray reports an issue while the image is being looked up
+	cache.invalidate("abc")
+	fresh := func() (ScanResult, error) {
+		lookups++
+		return ScanResult{Checksum: "abc", Recognized: true}, nil
+	}
+	// a lookup after the report does not wait for the stale one
+	if res, err := cache.get(context.Background(), "abc", fresh); err != nil || !res.Recognized {
+		t.Errorf("get after invalidate = %+v, %v", res, err)
+	}
+	close(release)
+	<-done
+	// the stale result does not replace the fresh one in the cache
+	if res, err := cache.get(context.Background(), "abc", fresh); err != nil || !res.Recognized {
+		t.Errorf("get after the stale lookup = %+v, %v", res, err)
+	}
+	if lookups != 2 {
+		t.Errorf("looked up %d times, want 2", lookups)
+	}
+}
+
+func TestScanCacheExpiry(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        CacheOptions
+		recognized  bool
+		err         error
+		wantLookups int
+	}{
+		{"cached", CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute}, true, nil, 1},
+		{"expired", CacheOptions{TTL: time.Nanosecond, UnrecognizedTTL: time.Minute}, true, nil, 2},
+		{"unrecognized cached", CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute}, false, nil, 1},
+		{"unrecognized expired", CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Nanosecond}, false, nil, 2},
+		{"negative caching disabled", CacheOptions{TTL: time.Minute}, false, nil, 2},
+		{"cache disabled", CacheOptions{UnrecognizedTTL: time.Minute}, false, nil, 2},
+		{"failures not cached", CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute}, true, errors.New("unreachable"), 2},
+	}
+	for _, tt := range tests {
+		cache := newScanCache(tt.opts)
+		lookups := 0
+		lookup := func() (ScanResult, error) {
+			lookups++
+			return ScanResult{Checksum: "abc", Recognized: tt.recognized}, tt.err
+		}
+		for i := 0; i < 2; i++ {
+			cache.get(context.Background(), "abc", lookup)
+			time.Sleep(time.Millisecond)
+		}
+		if lookups != tt.wantLookups {
+			t.Errorf("%s: looked up %d times, want %d", tt.name, lookups, tt.wantLookups)
+		}
+	}
+}
+
+func TestScanCacheInvalidate(t *testing.T) {
+	cache := newScanCache(CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute})
+	lookups := 0
+	lookup := func() (ScanResult, error) {
+		lookups++
+		return ScanResult{Checksum: "abc", Recognized: true}, nil
+	}
+	cache.get(context.Background(), "abc", lookup)
+	cache.invalidate("abc")
+	cache.get(context.Background(), "abc", lookup)
+	if lookups != 2 {
+		t.Errorf("looked up %d times, want 2", lookups)
+	}
+}
+
+func TestScanCachePrefetch(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       CacheOptions
+		err        error
+		wantBatch  int
+		wantCached bool
+		wantErr    bool
+	}{
+		{"cached", CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute}, nil, 2, true, false},
+		{"cache disabled", CacheOptions{}, nil, 2, false, false},
+		{"failed batch", CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute}, errors.New("unreachable"), 2, false, true},
+	}
+	for _, tt := range tests {
+		cache := newScanCache(tt.opts)
+		batches := 0
+		batch := cache.prefetch([]string{"abc", "def"}, func(shas []string) (map[string]ScanResult, error) {
+			batches++
+			if tt.err != nil {
+				return nil, tt.err
+			}
+			// xray leaves out the digests it does not know
+			return map[string]ScanResult{"abc": {Checksum: "abc", Recognized: true}}, nil
+		})
+		if batches != 1 || len(batch) != tt.wantBatch {
+			t.Errorf("%s: %d batches with %d outcomes, want 1 with %d", tt.name, batches, len(batch), tt.wantBatch)
+			continue
+		}
+		if (batch["abc"].err != nil) != tt.wantErr || (batch["def"].err != nil) != tt.wantErr {
+			t.Errorf("%s: errors %v and %v, want error %v", tt.name, batch["abc"].err, batch["def"].err, tt.wantErr)
+		}
+		if !tt.wantErr && (!batch["abc"].res.Recognized || batch["def"].res.Recognized) {
+			t.Errorf("%s: results %+v and %+v", tt.name, batch["abc"].res, batch["def"].res)
+		}
+		// digests already cached are not looked up again
+		again := cache.prefetch([]string{"abc"}, func(shas []string) (map[string]ScanResult, error) {
+			batches++
+			return nil, nil
+		})
+		if cached := batches == 1; cached != tt.wantCached || (cached && len(again) != 0) {
+			t.Errorf("%s: prefetched again with %d batches, want cached %v", tt.name, batches, tt.wantCached)
+		}
+	}
+}
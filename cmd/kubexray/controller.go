@@ -1,10 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
-	log "github.com/Sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -21,6 +22,8 @@ type Controller struct {
 	queue     workqueue.RateLimitingInterface
 	informer  cache.SharedIndexInformer
 	handler   Handler
+	// cancelled when the controller stops, aborting the checks in progress
+	ctx context.Context
 }
 
 // Run is the main path of execution for the controller loop
@@ -32,6 +35,7 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	defer c.queue.ShutDown()
 
 	c.logger.Debug("Controller.Run: initiating")
+	c.ctx = wait.ContextForChannel(stopCh)
 
 	// run the informer to start listing and watching resources
 	go c.informer.Run(stopCh)
@@ -120,11 +124,11 @@ func (c *Controller) processNextQueueItem() bool {
 	// a code path of successful queue item processing
 	if !exists {
 		c.logger.Debugf("Controller.processNextQueueItem: object deleted detected: %s", indexKey)
-		c.handler.ObjectDeleted(c.clientset, item)
+		c.handler.ObjectDeleted(c.ctx, c.clientset, item)
 		c.queue.Forget(item)
 	} else {
 		c.logger.Debugf("Controller.processNextQueueItem: object created detected: %s", indexKey)
-		c.handler.ObjectCreated(c.clientset, item)
+		c.handler.ObjectCreated(c.ctx, c.clientset, item)
 		c.queue.Forget(item)
 	}
 
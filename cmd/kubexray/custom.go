@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// the name of the cluster policy used in place of config.yaml
+const defaultPolicyName = "default"
+
+var (
+	// the cluster-scoped KubeXrayPolicy resource, with the same spec as config.yaml
+	clusterPolicyResource = schema.GroupVersionResource{Group: "kubexray.io", Version: "v1alpha1", Resource: "kubexraypolicies"}
+	// the namespaced KubeXrayNamespacePolicy resource, with the same spec as
+	// an entry of the namespaces section of config.yaml
+	namespacePolicyResource = schema.GroupVersionResource{Group: "kubexray.io", Version: "v1alpha1", Resource: "kubexraynamespacepolicies"}
+)
+
+// CustomPolicies is the set of policies defined as custom resources.
+type CustomPolicies struct {
+	// the config of the default KubeXrayPolicy, if any
+	base *Config
+	// the overrides of the KubeXrayNamespacePolicy resources
+	namespaces []NamespacePolicy
+}
+
+// merge the custom policies with the config read from config.yaml, the
+// default cluster policy replacing it and the namespace policies taking
+// precedence over its namespaces section
+func (c *CustomPolicies) merge(conf Config) Config {
+	if c == nil {
+		return conf
+	}
+	if c.base != nil {
+		conf = *c.base
+	}
+	namespaces := make([]NamespacePolicy, 0, len(c.namespaces)+len(conf.Namespaces))
+	namespaces = append(namespaces, c.namespaces...)
+	conf.Namespaces = append(namespaces, conf.Namespaces...)
+	return conf
+}
+
+// get the spec of a custom resource in the yaml format of config.yaml
+func readSpec(obj *unstructured.Unstructured) ([]byte, error) {
+	spec, ok := obj.Object["spec"]
+	if !ok {
+		return nil, errors.New("resource has no spec")
+	}
+	// json is a subset of yaml, so the config.yaml parsers read it as is
+	return json.Marshal(spec)
+}
+
+// parse the spec of a KubeXrayNamespacePolicy, which applies to its own
+// namespace, rejecting unknown keys like config.yaml does
+func parseNamespacePolicy(obj *unstructured.Unstructured) (NamespacePolicy, error) {
+	spec, err := readSpec(obj)
+	if err != nil {
+		return NamespacePolicy{}, err
+	}
+	fields, _ := obj.Object["spec"].(map[string]interface{})
+	for key := range fields {
+		err = checkKey(key, []string{"namespace", "selector", "unscanned", "security", "license"})
+		if err != nil {
+			return NamespacePolicy{}, err
+		}
+	}
+	var pol NamespacePolicy
+	err = yaml.UnmarshalStrict(spec, &pol)
+	if err != nil {
+		return NamespacePolicy{}, err
+	}
+	pol.Namespace = obj.GetNamespace()
+	pol.Selector = ""
+	return pol, pol.init()
+}
+
+// record whether a custom resource was accepted in its status conditions,
+// unless they already say so
+func setCondition(dyn dynamic.Interface, gvr schema.GroupVersionResource, obj *unstructured.Unstructured, err error, reason string) {
+	cond := map[string]interface{}{"type": "Accepted", "status": "True", "reason": "Valid", "message": ""}
+	if err != nil {
+		cond = map[string]interface{}{"type": "Invalid", "status": "True", "reason": reason, "message": err.Error()}
+	}
+	conds, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if len(conds) == 1 {
+		if old, ok := conds[0].(map[string]interface{}); ok {
+			same := true
+			for key, val := range cond {
+				same = same && reflect.DeepEqual(old[key], val)
+			}
+			if same {
+				return
+			}
+		}
+	}
+	cond["lastTransitionTime"] = time.Now().UTC().Format(time.RFC3339)
+	obj = obj.DeepCopy()
+	unstructured.SetNestedSlice(obj.Object, []interface{}{cond}, "status", "conditions")
+	_, err = dyn.Resource(gvr).Namespace(obj.GetNamespace()).UpdateStatus(context.TODO(), obj, meta_v1.UpdateOptions{})
+	if err != nil {
+		log.Warnf("Cannot update status of %s %s: %s", obj.GetKind(), obj.GetName(), err)
+	}
+}
+
+// get the custom resources in a store, ordered by namespace and name
+func sortedObjects(store cache.Store) []*unstructured.Unstructured {
+	objs := make([]*unstructured.Unstructured, 0)
+	for _, item := range store.List() {
+		if obj, ok := item.(*unstructured.Unstructured); ok {
+			objs = append(objs, obj)
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		if objs[i].GetNamespace() != objs[j].GetNamespace() {
+			return objs[i].GetNamespace() < objs[j].GetNamespace()
+		}
+		return objs[i].GetName() < objs[j].GetName()
+	})
+	return objs
+}
+
+// validate the policy custom resources and apply them, keeping the current
+// default cluster policy if the new one is invalid
+func syncCustomPolicies(t *HandlerImpl, dyn dynamic.Interface, clusterpols, nspols cache.Store) {
+	custom := &CustomPolicies{namespaces: make([]NamespacePolicy, 0)}
+	for _, obj := range sortedObjects(clusterpols) {
+		if obj.GetName() != defaultPolicyName {
+			err := errors.New("only the KubeXrayPolicy named " + defaultPolicyName + " is used")
+			setCondition(dyn, clusterPolicyResource, obj, err, "Ignored")
+			continue
+		}
+		spec, err := readSpec(obj)
+		var conf Config
+		if err == nil {
+			conf, err = parseConfig(spec)
+		}
+		setCondition(dyn, clusterPolicyResource, obj, err, "InvalidSpec")
+		if err != nil {
+			log.Warnf("Keeping the current policy, cannot read KubeXrayPolicy %s: %s", obj.GetName(), err)
+			if t.custom != nil {
+				custom.base = t.custom.base
+			}
+			continue
+		}
+		custom.base = &conf
+	}
+	for _, obj := range sortedObjects(nspols) {
+		pol, err := parseNamespacePolicy(obj)
+		setCondition(dyn, namespacePolicyResource, obj, err, "InvalidSpec")
+		if err != nil {
+			log.Warnf("Ignoring KubeXrayNamespacePolicy %s in %s: %s", obj.GetName(), obj.GetNamespace(), err)
+			continue
+		}
+		custom.namespaces = append(custom.namespaces, pol)
+	}
+	t.mu.Lock()
+	t.custom = custom
+	t.applyParsedConfig(custom.merge(t.fileConfig))
+	t.mu.Unlock()
+	log.Infof("Applied policy custom resources (%d namespace policies)", len(custom.namespaces))
+}
+
+// create an informer for the custom resources of the given type
+func newPolicyInformer(dyn dynamic.Interface, gvr schema.GroupVersionResource) cache.SharedIndexInformer {
+	lw := &cache.ListWatch{
+		ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+			return dyn.Resource(gvr).List(context.TODO(), opts)
+		},
+		WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+			return dyn.Resource(gvr).Watch(context.TODO(), opts)
+		},
+	}
+	return cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, 0, cache.Indexers{})
+}
+
+// watch the policy custom resources, if installed, and apply them whenever
+// they change
+func setupCustomPolicies(t *HandlerImpl, client kubernetes.Interface, config *rest.Config) {
+	gv := clusterPolicyResource.GroupVersion().String()
+	_, err := client.Discovery().ServerResourcesForGroupVersion(gv)
+	if err != nil {
+		log.Infof("Policy custom resources are not installed, using config.yaml: %s", err)
+		return
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Warnf("Cannot watch policy custom resources: %s", err)
+		return
+	}
+	clusterinf := newPolicyInformer(dyn, clusterPolicyResource)
+	nsinf := newPolicyInformer(dyn, namespacePolicyResource)
+	// coalesce bursts of changes into a single sync
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { notify() },
+		UpdateFunc: func(oldObj, newObj interface{}) { notify() },
+		DeleteFunc: func(obj interface{}) { notify() },
+	}
+	clusterinf.AddEventHandler(handler)
+	nsinf.AddEventHandler(handler)
+	stopCh := make(chan struct{})
+	go clusterinf.Run(stopCh)
+	go nsinf.Run(stopCh)
+	go func() {
+		// only apply the policies once all of them have been listed
+		if !cache.WaitForCacheSync(stopCh, clusterinf.HasSynced, nsinf.HasSynced) {
+			log.Warn("Cannot sync policy custom resources")
+			return
+		}
+		for range changed {
+			syncCustomPolicies(t, dyn, clusterinf.GetStore(), nsinf.GetStore())
+		}
+	}()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseNamespacePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    map[string]interface{}
+		want    Action
+		wantErr bool
+	}{
+		{"valid", map[string]interface{}{"security": map[string]interface{}{"deployments": "delete", "statefulSets": "delete"}}, Delete, false},
+		// the resource applies to its own namespace only
+		{"namespace ignored", map[string]interface{}{"namespace": "prod-*", "selector": "env=prod"}, Ignore, false},
+		{"unknown key", map[string]interface{}{"securty": map[string]interface{}{"deployments": "delete"}}, Ignore, true},
+		{"unknown action", map[string]interface{}{"security": map[string]interface{}{"deployments": "scaledwon", "statefulSets": "delete"}}, Ignore, true},
+		{"no spec", nil, Ignore, true},
+	}
+	for _, tt := range tests {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		obj.SetNamespace("prod-web")
+		if tt.spec != nil {
+			obj.Object["spec"] = tt.spec
+		}
+		pol, err := parseNamespacePolicy(obj)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if pol.Namespace != "prod-web" || pol.Selector != "" {
+			t.Errorf("%s: applies to namespace %q, selector %q", tt.name, pol.Namespace, pol.Selector)
+		}
+		got := Ignore
+		if pol.Security != nil {
+			got = pol.Security.action(Deployment)
+		}
+		if got != tt.want {
+			t.Errorf("%s: security action = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
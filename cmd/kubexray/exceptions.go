@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Exception encodes an entry of the exceptions section of the config.yaml
+// file, waiving a CVE or license until it expires, optionally only within
+// the given namespaces and/or for the given images.
+type Exception struct {
+	ID         string   `yaml:"id"`
+	Namespaces []string `yaml:"namespaces"`
+	Images     []string `yaml:"images"`
+	Expires    string   `yaml:"expires"`
+	expiry     time.Time
+}
+
+// validate an exception and parse its expiry
+func (x *Exception) init() error {
+	if x.ID == "" {
+		return errors.New("exception requires a CVE or license id")
+	}
+	if x.Expires == "" {
+		return errors.New("exception for " + x.ID + " requires an expiry")
+	}
+	expiry, err := parseExpiry(x.Expires)
+	if err != nil {
+		return errors.New("Cannot read expiry '" + x.Expires + "' of exception for " + x.ID + ".")
+	}
+	x.expiry = expiry
+	return nil
+}
+
+// convert a glob pattern to a regular expression, where '*' matches any
+// sequence of characters (including '/') and '?' matches any one character
+func globExpr(pattern string) string {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, "\\*", ".*", -1)
+	expr = strings.Replace(expr, "\\?", ".", -1)
+	return "^" + expr + "$"
+}
+
+// check whether a string matches a glob pattern
+func matchGlob(pattern, str string) bool {
+	ok, _ := regexp.MatchString(globExpr(pattern), str)
+	return ok
+}
+
+// check whether a string matches any of the given glob patterns, or there
+// are no patterns at all
+func matchAny(patterns []string, str string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchGlob(pattern, str) {
+			return true
+		}
+	}
+	return false
+}
+
+// check whether an exception waives the given issue of an image in a namespace
+func (x *Exception) matches(iss Issue, namespace, image string) bool {
+	if time.Now().After(x.expiry) {
+		return false
+	}
+	ids := append([]string{iss.ID}, iss.CVEs...)
+	found := false
+	for _, id := range ids {
+		if strings.EqualFold(id, x.ID) {
+			found = true
+		}
+	}
+	images := matchAny(x.Images, image) || matchAny(x.Images, unpinnedImage(image))
+	return found && matchAny(x.Namespaces, namespace) && images
+}
+
+// check whether any exception waives the given issue of an image in a namespace
+func (t *HandlerImpl) isExcepted(iss Issue, namespace, image string) bool {
+	for i := range t.exceptions {
+		if t.exceptions[i].matches(iss, namespace, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove the issues of an image in a namespace that are waived by an exception
+func (t *HandlerImpl) filterExceptions(issues []Issue, namespace, image string) []Issue {
+	result := make([]Issue, 0)
+	for _, iss := range issues {
+		if t.isExcepted(iss, namespace, image) {
+			log.Debugf("Ignoring %s issue %s of %s (due to exception)", iss.Type, iss.ID, image)
+			continue
+		}
+		result = append(result, iss)
+	}
+	return result
+}
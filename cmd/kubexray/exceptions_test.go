@@ -0,0 +1,85 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestGlobExpr(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"nginx", "nginx", true},
+		{"nginx", "nginx:1.17", false},
+		{"nginx:*", "nginx:1.17", true},
+		{"*", "docker.io/library/nginx:1.17", true},
+		{"docker.io/*", "docker.io/library/nginx", true},
+		{"docker.io/*", "gcr.io/docker.io/nginx", false},
+		{"nginx:1.1?", "nginx:1.17", true},
+		{"nginx:1.1?", "nginx:1.1", false},
+		{"team.example.com/app", "teamXexample.com/app", false},
+		{"app+1", "app+1", true},
+		{"kube-*", "kube-system", true},
+		{"kube-*", "default", false},
+	}
+	for _, tt := range tests {
+		got := regexp.MustCompile(globExpr(tt.pattern)).MatchString(tt.str)
+		if got != tt.want {
+			t.Errorf("glob %q matching %q = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestExceptionMatches(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	cve := Issue{Type: "security", ID: "XRAY-1234", CVEs: []string{"CVE-2020-1234"}}
+	license := Issue{Type: "license", ID: "GPL-3.0"}
+	tests := []struct {
+		name      string
+		exception Exception
+		issue     Issue
+		namespace string
+		image     string
+		want      bool
+	}{
+		{"by cve", Exception{ID: "CVE-2020-1234", expiry: future}, cve, "default", "nginx:1.17", true},
+		{"by xray id", Exception{ID: "XRAY-1234", expiry: future}, cve, "default", "nginx:1.17", true},
+		{"case insensitive", Exception{ID: "cve-2020-1234", expiry: future}, cve, "default", "nginx:1.17", true},
+		{"by license", Exception{ID: "GPL-3.0", expiry: future}, license, "default", "nginx:1.17", true},
+		{"other id", Exception{ID: "CVE-2020-9999", expiry: future}, cve, "default", "nginx:1.17", false},
+		{"expired", Exception{ID: "CVE-2020-1234", expiry: past}, cve, "default", "nginx:1.17", false},
+		{"in namespace", Exception{ID: "CVE-2020-1234", Namespaces: []string{"team-*"}, expiry: future}, cve, "team-a", "nginx:1.17", true},
+		{"other namespace", Exception{ID: "CVE-2020-1234", Namespaces: []string{"team-*"}, expiry: future}, cve, "default", "nginx:1.17", false},
+		{"for image", Exception{ID: "CVE-2020-1234", Images: []string{"nginx:*"}, expiry: future}, cve, "default", "nginx:1.17", true},
+		{"for pinned image", Exception{ID: "CVE-2020-1234", Images: []string{"nginx:1.17"}, expiry: future}, cve, "default", "nginx:1.17@sha256:abc", true},
+		{"other image", Exception{ID: "CVE-2020-1234", Images: []string{"redis:*"}, expiry: future}, cve, "default", "nginx:1.17", false},
+	}
+	for _, tt := range tests {
+		if got := tt.exception.matches(tt.issue, tt.namespace, tt.image); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExceptionInit(t *testing.T) {
+	tests := []struct {
+		exception Exception
+		wantErr   bool
+	}{
+		{Exception{ID: "CVE-2020-1234", Expires: "2030-01-01"}, false},
+		{Exception{ID: "CVE-2020-1234", Expires: "2030-01-01T12:00:00Z"}, false},
+		{Exception{ID: "CVE-2020-1234"}, true},
+		{Exception{Expires: "2030-01-01"}, true},
+		{Exception{ID: "CVE-2020-1234", Expires: "next year"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.exception.init()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("init(%+v) error = %v, want error %v", tt.exception, err, tt.wantErr)
+		}
+	}
+}
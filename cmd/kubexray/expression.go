@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	log "github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ExpressionPolicy is a compiled CEL expression deciding the action to take
+// against a pod, in place of the unscanned, security and license policies.
+// It sees the pod, owner, workload, namespace and scan variables, and
+// evaluates to the name of an action as used in config.yaml.
+type ExpressionPolicy struct {
+	program cel.Program
+}
+
+// compile a policy expression
+func compileExpression(expr string) (*ExpressionPolicy, error) {
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewIdent("pod", decls.Dyn, nil),
+		decls.NewIdent("owner", decls.Dyn, nil),
+		decls.NewIdent("workload", decls.Dyn, nil),
+		decls.NewIdent("namespace", decls.Dyn, nil),
+		decls.NewIdent("scan", decls.Dyn, nil),
+	))
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(expr)
+	if iss != nil && iss.Err() != nil {
+		return nil, errors.New("Cannot compile policy expression: " + iss.Err().Error())
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, errors.New("Cannot compile policy expression: " + err.Error())
+	}
+	return &ExpressionPolicy{program: prg}, nil
+}
+
+// convert a value to the generic maps and lists the expression operates on
+func toInput(val interface{}) interface{} {
+	if val == nil {
+		return nil
+	}
+	body, err := json.Marshal(val)
+	if err != nil {
+		return nil
+	}
+	var result interface{}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil
+	}
+	return result
+}
+
+// evaluate the expression for a pod (which may be nil) and its scan results
+func (x *ExpressionPolicy) action(client kubernetes.Interface, pod *core_v1.Pod, owner Owner, scan PodScan) (Action, error) {
+	var workload interface{}
+	if pod != nil && owner.Type == Pod {
+		workload = toInput(pod)
+	} else if owner.Type != Unrecognized {
+		obj, _, err := getObject(client, owner.Namespace, resourceKinds[owner.Type].Kind, owner.Name)
+		if err != nil {
+			log.Debugf("Cannot read %s %s for policy expression: %v", owner.Type, owner.Name, err)
+		} else {
+			workload = toInput(obj)
+		}
+	}
+	var podinput interface{}
+	if pod != nil {
+		podinput = toInput(pod)
+	}
+	nslabels := make(map[string]interface{})
+	for key, val := range namespaceLabels(client, owner.Namespace) {
+		nslabels[key] = val
+	}
+	input := map[string]interface{}{
+		"pod":      podinput,
+		"owner":    map[string]interface{}{"kind": owner.Type.String(), "name": owner.Name, "namespace": owner.Namespace},
+		"workload": workload,
+		"namespace": map[string]interface{}{
+			"name":   owner.Namespace,
+			"labels": nslabels,
+		},
+		"scan": map[string]interface{}{
+			"recognized": scan.recognized(),
+			"severity":   scan.severity().String(),
+			"issues":     toInput(scan.issues()),
+			"images":     toInput(scan.Results),
+		},
+	}
+	out, _, err := x.program.Eval(input)
+	if err != nil {
+		return Ignore, err
+	}
+	act, ok := out.Value().(string)
+	if !ok {
+		return Ignore, errors.New("policy expression did not evaluate to an action name")
+	}
+	key := policyKeys[owner.Type]
+	return readAction(map[string]interface{}{key: act}, key, true)
+}
+
+// get the action to take against a pod with the given scan results, using
+// the policy expression if configured and the policies otherwise; namespace
+// overrides and annotations still apply to the violations they cover
+func (t *HandlerImpl) decide(client kubernetes.Interface, pols Policies, pod *core_v1.Pod, owner Owner, scan PodScan) Action {
+	if t.expression == nil {
+		seciss, liciss := scan.flags()
+		return pols.action(owner.Type, scan.recognized(), seciss, liciss)
+	}
+	// the expression sees every issue, while the policies only act upon
+	// those at or above their thresholds
+	seciss, liciss := scan.atSeverity(pols.security.threshold(), pols.license.threshold()).flags()
+	act, err := t.expression.action(client, pod, owner, scan)
+	if err != nil {
+		// fall back to the policies rather than leaving the pod unchecked
+		log.Warnf("Cannot evaluate policy expression for %s %s: %s", owner.Type, owner.Name, err)
+		return pols.action(owner.Type, scan.recognized(), seciss, liciss)
+	}
+	override, rest := pols.overrideAction(owner.Type, scan.recognized(), seciss, liciss)
+	if !rest || override.severity() > act.severity() {
+		return override
+	}
+	return act
+}
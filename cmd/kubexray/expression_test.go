@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jfrog/kubexray/xray"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDecideSeverityThreshold(t *testing.T) {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       core_v1.PodSpec{Containers: []core_v1.Container{{Name: "web", Image: "web@sha256:" + vulnerableDigest}}},
+	}
+	owner := Owner{Type: Pod, Name: "web", Namespace: "default"}
+	// the only issue is High, below the threshold of the security policy
+	pols := Policies{security: Policy{pods: Evict, minSeverity: Critical}}
+	tests := []struct {
+		name       string
+		expression string
+		want       Action
+	}{
+		{"policies", "", Ignore},
+		{"expression sees every issue", `scan.issues.size() > 0 ? "delete" : "ignore"`, Delete},
+		{"expression sees the severity", `scan.severity == "High" ? "label" : "ignore"`, Label},
+	}
+	for _, tt := range tests {
+		srv, _ := batchXray(t, func([]string) bool { return false })
+		handler := &HandlerImpl{
+			url:   srv.URL,
+			xray:  xray.NewClient(srv.URL, "", "", xray.Options{}),
+			cache: newScanCache(CacheOptions{}),
+			batch: true,
+		}
+		if tt.expression != "" {
+			expr, err := compileExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			handler.expression = expr
+		}
+		client := fake.NewSimpleClientset()
+		scan := getPodInfo(context.Background(), handler, client, pols, pod)
+		if got := handler.decide(client, pols, pod, owner, scan); got != tt.want {
+			t.Errorf("%s: decide = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseWebhookExpression(t *testing.T) {
+	body := xray.WebhookPayload{Issues: []xray.WebhookIssue{{
+		Type:              "security",
+		Severity:          "Low",
+		Summary:           "XRAY-1",
+		ImpactedArtifacts: []xray.ImpactedArtifact{{PkgType: "Docker", Checksum: vulnerableDigest}},
+	}}}
+	handler := &HandlerImpl{security: Policy{minSeverity: High}}
+	if got := parseWebhook(handler, body); len(got) != 0 {
+		t.Errorf("policies: kept %d issues below the threshold", len(got))
+	}
+	expr, err := compileExpression(`"ignore"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.expression = expr
+	if got := parseWebhook(handler, body); len(got) != 1 {
+		t.Errorf("expression: kept %d issues, want 1", len(got))
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	log "github.com/Sirupsen/logrus"
+	"github.com/jfrog/kubexray/xray"
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,9 +26,9 @@ import (
 // Handler interface contains the methods that are required
 type Handler interface {
 	Init(client kubernetes.Interface, config *rest.Config) error
-	ObjectCreated(client kubernetes.Interface, obj interface{})
-	ObjectDeleted(client kubernetes.Interface, obj interface{})
-	ObjectUpdated(client kubernetes.Interface, objOld, objNew interface{})
+	ObjectCreated(ctx context.Context, client kubernetes.Interface, obj interface{})
+	ObjectDeleted(ctx context.Context, client kubernetes.Interface, obj interface{})
+	ObjectUpdated(ctx context.Context, client kubernetes.Interface, objOld, objNew interface{})
 }
 
 // ResourceType represents the type of Kubernetes resource a pod belongs to.
@@ -32,6 +38,12 @@ const (
 	Unrecognized ResourceType = iota
 	StatefulSet
 	Deployment
+	DaemonSet
+	Job
+	CronJob
+	Pod
+	ReplicaSet
+	ReplicationController
 )
 
 // Action represents the action taken against a problematic pod.
@@ -41,13 +53,65 @@ const (
 	Ignore Action = iota
 	Scaledown
 	Delete
+	Suspend
+	Evict
+	Isolate
+	Label
 )
 
+// String returns the name of the action as used in config.yaml.
+func (a Action) String() string {
+	switch a {
+	case Scaledown:
+		return "scaledown"
+	case Delete:
+		return "delete"
+	case Suspend:
+		return "suspend"
+	case Evict:
+		return "evict"
+	case Isolate:
+		return "isolate"
+	case Label:
+		return "label"
+	}
+	return "ignore"
+}
+
+// get the relative severity of an action, used to pick the strongest action
+// when several policies apply
+func (a Action) severity() int {
+	switch a {
+	case Delete:
+		return 4
+	case Scaledown, Suspend, Evict:
+		return 3
+	case Isolate:
+		return 2
+	case Label:
+		return 1
+	}
+	return 0
+}
+
 // Policy encodes the policy structures in the config.yaml file.
 type Policy struct {
-	deployments  Action
-	statefulSets Action
-	whitelist []string
+	deployments            Action
+	statefulSets           Action
+	daemonSets             Action
+	jobs                   Action
+	cronJobs               Action
+	pods                   Action
+	replicaSets            Action
+	replicationControllers Action
+	minSeverity            Severity
+	whitelist              []string
+	// leave the images of init and ephemeral containers to the other policies
+	ignoreInit      bool
+	ignoreEphemeral bool
+	// set by namespace overrides and annotations, which take precedence
+	// over the policy expression
+	overridden bool
 }
 
 // HandlerImpl is a sample implementation of Handler
@@ -58,15 +122,50 @@ type HandlerImpl struct {
 	pass         string
 	slackWebhook string
 	webhookToken string
+	xray         *xray.Client
+	cache        *ScanCache
+	batch        bool
 	unscanned    Policy
 	security     Policy
 	license      Policy
+	namespaces   []NamespacePolicy
+	exceptions   []Exception
+	images       ImagePolicy
+	expression   *ExpressionPolicy
+	admission    AdmissionPolicy
+	dryRun       bool
+	// the config read from config.yaml, and the policy custom resources
+	// merged into it
+	fileConfig Config
+	custom     *CustomPolicies
+	// guards the configuration above, which is swapped on reload
+	mu sync.RWMutex
+	// the contents of the config files, to detect changes
+	configData     []byte
+	xrayConfigData []byte
+	webhookStarted bool
+}
+
+// Config encodes the config.yaml file.
+type Config struct {
+	Unscanned  Policy            `yaml:"unscanned"`
+	Security   Policy            `yaml:"security"`
+	License    Policy            `yaml:"license"`
+	Namespaces []NamespacePolicy `yaml:"namespaces"`
+	Exceptions []Exception       `yaml:"exceptions"`
+	Images     ImagePolicy       `yaml:"images"`
+	Expression string            `yaml:"expression"`
+	Admission  AdmissionPolicy   `yaml:"admission"`
+	DryRun     bool              `yaml:"dryRun"`
+	expression *ExpressionPolicy
 }
 
 // NotifyComponentPayload is a component structure in NotifyPayload.
 type NotifyComponentPayload struct {
-	Name     string `json:"component_name"`
-	Checksum string `json:"component_sha"`
+	Name     string  `json:"component_name"`
+	Checksum string  `json:"component_sha"`
+	Role     string  `json:"container_role,omitempty"`
+	Issues   []Issue `json:"issues,omitempty"`
 }
 
 // NotifyPayload is the payload used to notify xray of changes.
@@ -76,6 +175,7 @@ type NotifyPayload struct {
 	Action     string                   `json:"action"`
 	Cluster    string                   `json:"cluster_url"`
 	Components []NotifyComponentPayload `json:"components"`
+	DryRun     bool                     `json:"dry_run,omitempty"`
 }
 
 // UnmarshalYAML is the unmarshaler implementation for the Policy type.
@@ -85,40 +185,152 @@ func (x *Policy) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err != nil {
 		return err
 	}
-	deps, _ := k["deployments"].(string)
-	switch deps {
-	case "ignore":
-		x.deployments = Ignore
-	case "scaledown":
-		x.deployments = Scaledown
-	case "delete":
-		x.deployments = Delete
-	default:
-		return errors.New("Cannot read action with value '" + deps + "'.")
+	actions := []struct {
+		key      string
+		required bool
+		action   *Action
+	}{
+		{"deployments", true, &x.deployments},
+		{"statefulSets", true, &x.statefulSets},
+		{"daemonSets", false, &x.daemonSets},
+		{"jobs", false, &x.jobs},
+		{"cronJobs", false, &x.cronJobs},
+		{"pods", false, &x.pods},
+		{"replicaSets", false, &x.replicaSets},
+		{"replicationControllers", false, &x.replicationControllers},
 	}
-	sets, _ := k["statefulSets"].(string)
-	switch sets {
-	case "ignore":
-		x.statefulSets = Ignore
-	case "scaledown":
-		x.statefulSets = Scaledown
-	case "delete":
-		x.statefulSets = Delete
-	default:
-		return errors.New("Cannot read action with value '" + sets + "'.")
+	known := []string{"minSeverity", "whitelistNamespaces", "initContainers", "ephemeralContainers"}
+	for _, a := range actions {
+		known = append(known, a.key)
+	}
+	for key := range k {
+		err = checkKey(key, known)
+		if err != nil {
+			return inPolicy(k, err)
+		}
+	}
+	for _, a := range actions {
+		*a.action, err = readAction(k, a.key, a.required)
+		if err != nil {
+			return keyError(k, a.key, err)
+		}
+	}
+	x.minSeverity, err = readSeverity(k, "minSeverity")
+	if err != nil {
+		return keyError(k, "minSeverity", err)
 	}
 	whitelist := make([]string, 0)
-	whitelists, _ := k["whitelistNamespaces"].([]interface{})
-	for _, ns := range whitelists {
-		namespace, ok := ns.(string)
-		if ok {
+	switch whitelists := k["whitelistNamespaces"].(type) {
+	case nil:
+	case string:
+		// the helm chart writes the whitelist as a comma separated string
+		for _, ns := range strings.Split(whitelists, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				whitelist = append(whitelist, ns)
+			}
+		}
+	case []interface{}:
+		for _, ns := range whitelists {
+			namespace, ok := ns.(string)
+			if !ok {
+				return keyError(k, "whitelistNamespaces", errors.New("namespaces must be strings"))
+			}
 			whitelist = append(whitelist, namespace)
 		}
+	default:
+		return keyError(k, "whitelistNamespaces", errors.New("expected a list or a comma separated string of namespaces"))
 	}
 	x.whitelist = whitelist
+	roles := []struct {
+		key    string
+		ignore *bool
+	}{
+		{"initContainers", &x.ignoreInit},
+		{"ephemeralContainers", &x.ignoreEphemeral},
+	}
+	for _, r := range roles {
+		switch checked := k[r.key].(type) {
+		case nil:
+			*r.ignore = false
+		case bool:
+			*r.ignore = !checked
+		default:
+			return keyError(k, r.key, errors.New("expected true or false"))
+		}
+	}
 	return nil
 }
 
+// check whether this policy acts upon the images of containers with the given role
+func (x Policy) checks(role string) bool {
+	switch role {
+	case initContainerRole:
+		return !x.ignoreInit
+	case ephemeralContainerRole:
+		return !x.ignoreEphemeral
+	}
+	return true
+}
+
+// read the action configured under the given key of a policy, defaulting to
+// ignore when an optional key is missing
+func readAction(k map[string]interface{}, key string, required bool) (Action, error) {
+	val, ok := k[key]
+	if !ok && !required {
+		return Ignore, nil
+	}
+	act, _ := val.(string)
+	switch act {
+	case "ignore":
+		return Ignore, nil
+	case "scaledown":
+		// bare pods have no replica count to scale down
+		if key != "pods" {
+			return Scaledown, nil
+		}
+	case "delete":
+		return Delete, nil
+	case "isolate":
+		return Isolate, nil
+	case "label":
+		return Label, nil
+	case "suspend":
+		// only cron jobs can be suspended
+		if key == "cronJobs" {
+			return Suspend, nil
+		}
+	case "evict":
+		// only bare pods can be evicted
+		if key == "pods" {
+			return Evict, nil
+		}
+	}
+	return Ignore, errors.New("Cannot read action with value '" + act + "'.")
+}
+
+// get the action this policy requires for the given resource type
+func (x Policy) action(typ ResourceType) Action {
+	switch typ {
+	case Deployment:
+		return x.deployments
+	case StatefulSet:
+		return x.statefulSets
+	case DaemonSet:
+		return x.daemonSets
+	case Job:
+		return x.jobs
+	case CronJob:
+		return x.cronJobs
+	case Pod:
+		return x.pods
+	case ReplicaSet:
+		return x.replicaSets
+	case ReplicationController:
+		return x.replicationControllers
+	}
+	return Ignore
+}
+
 // Init initializes the handler with configuration data.
 func (t *HandlerImpl) Init(client kubernetes.Interface, config *rest.Config) error {
 	log.Debug("HandlerImpl.Init")
@@ -127,62 +339,174 @@ func (t *HandlerImpl) Init(client kubernetes.Interface, config *rest.Config) err
 		host += "/"
 	}
 	t.clusterurl = host
-	url, user, pass, slack, token, err := getXrayConfig("/config/secret/xray_config.yaml", "./xray_config.yaml")
+	file, err := readConfigFile(xrayConfigPath, xrayConfigFallback)
+	if err == nil {
+		err = t.applyXrayConfig(file)
+	}
 	if err != nil {
 		log.Error("Cannot read xray_config.yaml: ", err)
 		return err
 	}
-	t.url = url
-	t.user = user
-	t.pass = pass
-	t.slackWebhook = slack
-	t.webhookToken = token
-	unscanned, security, license, err := getConfig("/config/conf/config.yaml", "./config.yaml")
+	err = t.loadConfig(configPath, configFallback)
 	if err != nil {
-		log.Warn("Cannot read config.yaml: ", err)
+		return err
 	}
-	t.unscanned = unscanned
-	t.security = security
-	t.license = license
 	if t.webhookToken != "" {
 		setupXrayWebhook(t, client)
 	}
+	setupRecovery(t, client)
+	setupCache(t)
+	setupReload(t, client)
+	setupCustomPolicies(t, client, config)
+	setupAdmissionWebhook(t, client)
+	return nil
+}
+
+// read and apply the config.yaml file at startup; a missing file leaves
+// every violation ignored, while an invalid one is an error unless the
+// config is not strict
+func (t *HandlerImpl) loadConfig(path, path2 string) error {
+	file, err := readConfigFile(path, path2)
+	if err != nil {
+		log.Warn("Cannot read config.yaml, ignoring every violation: ", err)
+		t.fileConfig = Config{}
+		t.applyParsedConfig(Config{})
+		return nil
+	}
+	err = t.applyConfig(file)
+	if err != nil && strictConfig() {
+		log.Error("Invalid config.yaml: ", err)
+		return err
+	}
+	if err != nil {
+		log.Warn("Invalid config.yaml, ignoring every violation: ", err)
+		t.fileConfig = Config{}
+		t.applyParsedConfig(Config{})
+	}
+	return nil
+}
+
+// apply the contents of the xray_config.yaml file
+func (t *HandlerImpl) applyXrayConfig(file []byte) error {
+	conf, err := parseXrayConfig(file)
+	if err != nil {
+		return err
+	}
+	if t.cache == nil || t.cache.opts != conf.Cache || t.url != conf.URL {
+		t.cache = newScanCache(conf.Cache)
+	}
+	t.url = conf.URL
+	t.user = conf.User
+	t.pass = conf.Password
+	t.slackWebhook = conf.SlackWebhook
+	t.webhookToken = conf.WebhookToken
+	t.batch = conf.Batch
+	t.xray = xray.NewClient(conf.URL, conf.User, conf.Password, conf.Client)
+	t.xrayConfigData = file
 	return nil
 }
 
+// apply the contents of the config.yaml file
+func (t *HandlerImpl) applyConfig(file []byte) error {
+	conf, err := parseConfig(file)
+	if err != nil {
+		return err
+	}
+	t.fileConfig = conf
+	t.applyParsedConfig(t.custom.merge(conf))
+	t.configData = file
+	return nil
+}
+
+// apply the policies of the config.yaml file
+func (t *HandlerImpl) applyParsedConfig(conf Config) {
+	var err error
+	t.unscanned = conf.Unscanned
+	t.security = conf.Security
+	t.license = conf.License
+	t.namespaces = conf.Namespaces
+	t.exceptions = conf.Exceptions
+	t.images = conf.Images
+	t.expression = conf.expression
+	t.admission = conf.Admission
+	t.dryRun = conf.DryRun
+	// the environment overrides the config file, if provided
+	if dry, ok := os.LookupEnv("KUBE_XRAY_DRY_RUN"); ok {
+		t.dryRun, err = strconv.ParseBool(strings.TrimSpace(dry))
+		if err != nil {
+			log.Warnf("Unrecognized dry run value '%s'; expected true or false", dry)
+			t.dryRun = conf.DryRun
+		}
+	}
+	if t.dryRun {
+		log.Info("Running in dry run mode, no action will be taken against pods")
+	}
+}
+
+// get a copy of the current configuration to act upon, so that the lock is
+// not held across calls to xray, registries and the cluster, which would
+// stall reloads and, behind a waiting reload, every other reader
+func (t *HandlerImpl) snapshot() *HandlerImpl {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return &HandlerImpl{
+		clusterurl:   t.clusterurl,
+		url:          t.url,
+		user:         t.user,
+		pass:         t.pass,
+		slackWebhook: t.slackWebhook,
+		webhookToken: t.webhookToken,
+		xray:         t.xray,
+		cache:        t.cache,
+		batch:        t.batch,
+		unscanned:    t.unscanned,
+		security:     t.security,
+		license:      t.license,
+		namespaces:   t.namespaces,
+		exceptions:   t.exceptions,
+		images:       t.images,
+		expression:   t.expression,
+		admission:    t.admission,
+		dryRun:       t.dryRun,
+		fileConfig:   t.fileConfig,
+		custom:       t.custom,
+	}
+}
+
 // temporary structure for search results in webhook code
 type searchItem struct {
 	severity string
-	isstype string
-	sha2 string
-	name string
-	action string
-	pod *core_v1.Pod
+	isstype  string
+	sha2     string
+	name     string
+	action   string
+	role     string
+	pod      *core_v1.Pod
+	issue    Issue
 }
 
-// parses the xray webhook request body
-func parseWebhook(body interface{}) []searchItem {
+// parses the xray webhook request body, keeping the issues at or above the
+// configured severity thresholds
+func parseWebhook(t *HandlerImpl, body xray.WebhookPayload) []searchItem {
 	result := make([]searchItem, 0)
-	bodymap := body.(map[string]interface{})
-	for _, iss := range bodymap["issues"].([]interface{}) {
-		issue := iss.(map[string]interface{})
-		severity := issue["severity"].(string)
-		isstype := issue["type"].(string)
-		if (severity != "Major" && severity != "Critical" && severity != "High") || isstype == "" {
+	for _, issue := range body.Issues {
+		cves := make([]string, 0)
+		if issue.CVE != "" {
+			cves = append(cves, issue.CVE)
+		}
+		if issue.Type == "" || parseSeverity(issue.Severity) < t.minSeverity(issue.Type) {
 			continue
 		}
-		if _, ok := issue["impacted_artifacts"]; !ok {
+		if len(issue.ImpactedArtifacts) == 0 {
 			log.Debugf("Unable to process webhook, xray did not include impacted component data. Payload: %v", body)
 			continue
 		}
-		for _, art := range issue["impacted_artifacts"].([]interface{}) {
-			artif := art.(map[string]interface{})
-			pkgtype := artif["pkg_type"].(string)
-			sha2 := artif["sha256"].(string)
-			if pkgtype != "Docker" || sha2 == "" {
+		for _, artif := range issue.ImpactedArtifacts {
+			if artif.PkgType != "Docker" || artif.Checksum == "" {
 				continue
 			}
-			res := searchItem{severity, isstype, sha2, "", "", nil}
+			iss := Issue{Type: issue.Type, Severity: issue.Severity, ID: issue.Summary, CVEs: cves}
+			res := searchItem{severity: issue.Severity, isstype: issue.Type, sha2: artif.Checksum, issue: iss}
 			result = append(result, res)
 		}
 	}
@@ -193,31 +517,51 @@ func parseWebhook(body interface{}) []searchItem {
 // match active running containers
 func searchChecksums(client kubernetes.Interface, shas []searchItem) ([]searchItem, error) {
 	result := make([]searchItem, 0)
-	nss, err := client.CoreV1().Namespaces().List(meta_v1.ListOptions{})
+	nss, err := client.CoreV1().Namespaces().List(context.TODO(), meta_v1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 	for _, ns := range nss.Items {
-		pods, err := client.CoreV1().Pods(ns.Name).List(meta_v1.ListOptions{})
+		pods, err := client.CoreV1().Pods(ns.Name).List(context.TODO(), meta_v1.ListOptions{})
 		if err != nil {
 			return nil, err
 		}
-		for _, pod := range pods.Items {
-			for _, stat := range pod.Status.ContainerStatuses {
-				idx := strings.LastIndex(stat.ImageID, "sha256:")
-				if idx == -1 {
-					continue
-				}
-				sha2 := stat.ImageID[idx+7:]
-				for _, item := range shas {
-					if item.sha2 == sha2 {
-						res := item
-						res.name = stat.Image
-						res.pod = &pod
-						result = append(result, res)
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			// report the images as given in the spec, like scans of new pods
+			images := make(map[string]string)
+			for _, container := range pod.Spec.InitContainers {
+				images[container.Name] = container.Image
+			}
+			for _, container := range pod.Spec.Containers {
+				images[container.Name] = container.Image
+			}
+			for _, container := range pod.Spec.EphemeralContainers {
+				images[container.Name] = container.Image
+			}
+			match := func(statuses []core_v1.ContainerStatus, role string) {
+				for _, stat := range statuses {
+					sha2 := statusDigest(stat.ImageID)
+					if sha2 == "" {
+						continue
+					}
+					for _, item := range shas {
+						if item.sha2 == sha2 {
+							res := item
+							res.name = images[stat.Name]
+							if res.name == "" {
+								res.name = stat.Image
+							}
+							res.role = role
+							res.pod = pod
+							result = append(result, res)
+						}
 					}
 				}
 			}
+			match(pod.Status.InitContainerStatuses, initContainerRole)
+			match(pod.Status.ContainerStatuses, appContainerRole)
+			match(pod.Status.EphemeralContainerStatuses, ephemeralContainerRole)
 		}
 	}
 	return result, nil
@@ -225,8 +569,10 @@ func searchChecksums(client kubernetes.Interface, shas []searchItem) ([]searchIt
 
 // setup the webhook for xray to call
 func setupXrayWebhook(t *HandlerImpl, client kubernetes.Interface) {
+	t.webhookStarted = true
 	go func() {
 		http.HandleFunc("/", handleXrayWebhook(t, client))
+		http.HandleFunc("/restore", handleRestore(t, client))
 		err := http.ListenAndServe(":8765", nil)
 		if err != nil {
 			log.Errorf("Error running Xray webhook: %v", err)
@@ -234,14 +580,21 @@ func setupXrayWebhook(t *HandlerImpl, client kubernetes.Interface) {
 	}()
 }
 
+// check the auth token of a webhook request; every request is rejected while
+// no token is configured, such as after a reload dropped it
+func (t *HandlerImpl) authorized(req *http.Request) bool {
+	tok := req.Header.Get("X-Auth-Token")
+	return t.webhookToken != "" && subtle.ConstantTimeCompare([]byte(tok), []byte(t.webhookToken)) == 1
+}
+
 // handle when xray calls the webhook
 func handleXrayWebhook(t *HandlerImpl, client kubernetes.Interface) http.HandlerFunc {
 	return func(resp http.ResponseWriter, req *http.Request) {
 		log.Debug("Webhook triggered by Xray")
+		t := t.snapshot()
 		// check the auth token and fail if it's wrong
-		toks := req.Header["X-Auth-Token"]
-		if len(toks) <= 0 || toks[0] != t.webhookToken {
-			log.Warn("Xray did not send an appropriate token, aborting webhook: Provided token value is %v", toks)
+		if !t.authorized(req) {
+			log.Warn("Xray did not send an appropriate token, aborting webhook")
 			resp.WriteHeader(403)
 			return
 		}
@@ -252,7 +605,7 @@ func handleXrayWebhook(t *HandlerImpl, client kubernetes.Interface) http.Handler
 			resp.WriteHeader(400)
 			return
 		}
-		var data interface{}
+		var data xray.WebhookPayload
 		err = json.Unmarshal(body, &data)
 		if err != nil {
 			log.Errorf("Error reading webhook request: %v", err)
@@ -260,7 +613,11 @@ func handleXrayWebhook(t *HandlerImpl, client kubernetes.Interface) http.Handler
 			return
 		}
 		// find matching checksums in the cluster
-		searchterms := parseWebhook(data)
+		searchterms := parseWebhook(t, data)
+		for _, term := range searchterms {
+			// xray knows more about this image than when it was cached
+			t.cache.invalidate(term.sha2)
+		}
 		searchresult, err := searchChecksums(client, searchterms)
 		if err != nil {
 			log.Errorf("Error handling webhook request: %v", err)
@@ -268,57 +625,46 @@ func handleXrayWebhook(t *HandlerImpl, client kubernetes.Interface) http.Handler
 			return
 		}
 		// check each match against the config to decide how to deal with it
-		for _, term := range searchresult {
-			_, typ := checkResource(client, term.pod)
-			if isWhitelistedNamespace(t, term.pod, true, term.isstype == "security", term.isstype == "license") {
-				log.Debug("Ignoring pod: %s (due to whitelisted namespace: %s)", term.pod.Name, term.pod.Namespace)
+		for i := range searchresult {
+			term := &searchresult[i]
+			if t.images.allowed(term.name) {
+				log.Debugf("Ignoring pod: %s (due to allowed image: %s)", term.pod.Name, term.name)
 				continue
 			}
-			delete, scaledown := false, false
-			if typ == Deployment {
-				if term.isstype == "security" {
-					if t.security.deployments == Delete {
-						delete = true
-					} else if t.security.deployments == Scaledown {
-						scaledown = true
-					}
-				} else if term.isstype == "license" {
-					if t.license.deployments == Delete {
-						delete = true
-					} else if t.license.deployments == Scaledown {
-						scaledown = true
-					}
-				}
-			} else if typ == StatefulSet {
-				if term.isstype == "security" {
-					if t.security.statefulSets == Delete {
-						delete = true
-					} else if t.security.statefulSets == Scaledown {
-						scaledown = true
-					}
-				} else if term.isstype == "license" {
-					if t.license.statefulSets == Delete {
-						delete = true
-					} else if t.license.statefulSets == Scaledown {
-						scaledown = true
-					}
-				}
+			owner := checkResource(client, term.pod)
+			pols := applyOverrides(client, t.policiesFor(client, term.pod.Namespace), term.pod, owner)
+			if t.expression == nil && parseSeverity(term.severity) < pols.minSeverity(term.isstype) {
+				log.Debugf("Ignoring pod: %s (due to %s issue below namespace threshold)", term.pod.Name, term.severity)
+				continue
 			}
-			if delete || scaledown {
-				// remove the pod by either deleting it or scaling it to zero replicas
-				if delete {
-					term.action = "delete"
-				} else {
-					term.action = "scaledown"
-				}
-				removePod(client, term.pod, typ, delete)
+			if t.isExcepted(term.issue, term.pod.Namespace, term.name) {
+				log.Debugf("Ignoring pod: %s (due to exception for %s)", term.pod.Name, term.issue.ID)
+				continue
+			}
+			if isWhitelistedNamespace(pols, term.pod.Namespace, true, term.isstype == "security", term.isstype == "license") {
+				log.Debugf("Ignoring pod: %s (due to whitelisted namespace: %s)", term.pod.Name, term.pod.Namespace)
+				continue
+			}
+			res := ScanResult{Image: term.name, Checksum: term.sha2, Role: term.role, Recognized: true, Issues: []Issue{term.issue}}
+			if len(pols.filterRole(res).Issues) == 0 {
+				log.Debugf("Ignoring pod: %s (due to policy ignoring %s containers)", term.pod.Name, term.role)
+				continue
+			}
+			action := t.decide(client, pols, term.pod, owner, PodScan{Results: []ScanResult{res}})
+			if action != Ignore {
+				// remove the pod according to the policy
+				term.action = action.String()
+				comps := []NotifyComponentPayload{res.component()}
+				violation := Violation{Types: []string{term.isstype}, Severity: term.severity}
+				t.enforce(client, term.pod, owner, action, violation, comps)
 			} else {
 				log.Debugf("Ignoring pod: %s", term.pod.Name)
 			}
 		}
 		// send notification to xray
 		groups := make(map[types.UID][]*searchItem)
-		for _, item := range searchresult {
+		for i := range searchresult {
+			item := &searchresult[i]
 			if item.action == "" {
 				continue
 			}
@@ -326,24 +672,24 @@ func handleXrayWebhook(t *HandlerImpl, client kubernetes.Interface) http.Handler
 			if !ok {
 				group = make([]*searchItem, 0)
 			}
-			groups[item.pod.UID] = append(group, &item)
+			groups[item.pod.UID] = append(group, item)
 		}
 		for _, group := range groups {
 			comp := make([]NotifyComponentPayload, 0)
-			act := "scaledown"
+			act := group[0].action
 			for _, item := range group {
-				c := NotifyComponentPayload{Name: item.name, Checksum: item.sha2}
+				c := NotifyComponentPayload{Name: item.name, Checksum: item.sha2, Role: item.role, Issues: []Issue{item.issue}}
 				if item.action == "delete" {
 					act = "delete"
 				}
 				comp = append(comp, c)
 			}
-			payload := NotifyPayload{Name: group[0].pod.Name, Namespace: group[0].pod.Namespace, Action: act, Cluster: t.clusterurl, Components: comp}
+			payload := NotifyPayload{Name: group[0].pod.Name, Namespace: group[0].pod.Namespace, Action: act, Cluster: t.clusterurl, Components: comp, DryRun: t.dryRun}
 			// send a slack notification if applicable
 			if t.slackWebhook != "" {
-				notifyForPod(t.slackWebhook, payload, group[0].isstype == "security", group[0].isstype == "license")
+				notifyForPod(t.slackWebhook, payload)
 			}
-			err := sendXrayNotify(t, payload)
+			err := sendXrayNotify(req.Context(), t, payload)
 			if err != nil {
 				log.Errorf("Problem notifying xray about pod %s: %s", payload.Name, err)
 			}
@@ -353,49 +699,36 @@ func handleXrayWebhook(t *HandlerImpl, client kubernetes.Interface) http.Handler
 }
 
 // ObjectCreated is called when an object is created
-func (t *HandlerImpl) ObjectCreated(client kubernetes.Interface, obj interface{}) {
+func (t *HandlerImpl) ObjectCreated(ctx context.Context, client kubernetes.Interface, obj interface{}) {
 	pod := obj.(*core_v1.Pod)
 	log.Debug("HandlerImpl.ObjectCreated")
-	_, typ := checkResource(client, pod)
-	comps, rec, seciss, liciss := getPodInfo(t, pod)
-	if isWhitelistedNamespace(t, pod, rec, seciss, liciss) {
-		log.Debug("Ignoring pod: %s (due to whitelisted namespace: %s)", pod.Name, pod.Namespace)
+	t.snapshot().checkPod(ctx, client, pod)
+}
+
+// check a pod and enforce the policies against it
+func (t *HandlerImpl) checkPod(ctx context.Context, client kubernetes.Interface, pod *core_v1.Pod) {
+	owner := checkResource(client, pod)
+	pols := applyOverrides(client, t.policiesFor(client, pod.Namespace), pod, owner)
+	scan := getPodInfo(ctx, t, client, pols, pod)
+	comps := scan.components()
+	rec := scan.recognized()
+	seciss, liciss := scan.flags()
+	if isWhitelistedNamespace(pols, pod.Namespace, rec, seciss, liciss) {
+		log.Debugf("Ignoring pod: %s (due to whitelisted namespace: %s)", pod.Name, pod.Namespace)
 		return
 	}
-	delete, scaledown := false, false
-	check := func(pol Policy) {
-		if typ == Deployment && pol.deployments == Delete {
-			delete = true
-		} else if typ == Deployment && pol.deployments == Scaledown {
-			scaledown = true
-		} else if typ == StatefulSet && pol.statefulSets == Delete {
-			delete = true
-		} else if typ == StatefulSet && pol.statefulSets == Scaledown {
-			scaledown = true
-		}
-	}
-	if !rec {
-		check(t.unscanned)
-	}
-	if seciss {
-		check(t.security)
-	}
-	if liciss {
-		check(t.license)
-	}
+	action := t.decide(client, pols, pod, owner, scan)
 	act := ""
-	if delete {
-		act = "delete"
-	} else if scaledown {
-		act = "scaledown"
+	if action != Ignore {
+		act = action.String()
 	}
-	payload := NotifyPayload{Name: pod.Name, Namespace: pod.Namespace, Action: act, Cluster: t.clusterurl, Components: comps}
+	payload := NotifyPayload{Name: pod.Name, Namespace: pod.Namespace, Action: act, Cluster: t.clusterurl, Components: comps, DryRun: t.dryRun}
 	if t.slackWebhook != "" && (!rec || seciss || liciss) {
-		notifyForPod(t.slackWebhook, payload, seciss, liciss)
+		notifyForPod(t.slackWebhook, payload)
 	}
-	if delete || scaledown {
-		removePod(client, pod, typ, delete)
-		err := sendXrayNotify(t, payload)
+	if action != Ignore {
+		t.enforce(client, pod, owner, action, scan.violation(), comps)
+		err := sendXrayNotify(ctx, t, payload)
 		if err != nil {
 			log.Errorf("Problem notifying xray about pod %s: %s", payload.Name, err)
 		}
@@ -404,56 +737,49 @@ func (t *HandlerImpl) ObjectCreated(client kubernetes.Interface, obj interface{}
 	}
 }
 
+// take the given action against a pod, or just report it in dry run mode
+func (t *HandlerImpl) enforce(client kubernetes.Interface, pod *core_v1.Pod, owner Owner, action Action, violation Violation, comps []NotifyComponentPayload) {
+	if !t.dryRun {
+		removePod(client, pod, owner, action, violation, comps)
+		return
+	}
+	digests := make([]string, 0)
+	for _, comp := range comps {
+		digests = append(digests, comp.Name+" (sha256:"+comp.Checksum+")")
+	}
+	log.Infof("Dry run: would %s %s %s in %s, triggered by %s", action, owner.Type, owner.Name, owner.Namespace, strings.Join(digests, ", "))
+}
+
 // ObjectDeleted is called when an object is deleted
-func (t *HandlerImpl) ObjectDeleted(client kubernetes.Interface, obj interface{}) {
+func (t *HandlerImpl) ObjectDeleted(ctx context.Context, client kubernetes.Interface, obj interface{}) {
 	log.Debug("HandlerImpl.ObjectDeleted")
 }
 
 // ObjectUpdated is called when an object is updated
-func (t *HandlerImpl) ObjectUpdated(client kubernetes.Interface, objOld, objNew interface{}) {
+func (t *HandlerImpl) ObjectUpdated(ctx context.Context, client kubernetes.Interface, objOld, objNew interface{}) {
 	log.Debug("HandlerImpl.ObjectUpdated")
 }
 
 // send the notification to xray
-func sendXrayNotify(t *HandlerImpl, payload NotifyPayload) error {
+func sendXrayNotify(ctx context.Context, t *HandlerImpl, payload NotifyPayload) error {
 	log.Debugf("Sending message back to xray concerning pod %s", payload.Name)
-	client := &http.Client{}
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-	log.Debugf("Message body: %s", string(body))
-	req, err := http.NewRequest("POST", t.url+"/api/v1/kube/metadata", bytes.NewReader(body))
-	if err != nil {
-		return err
-	}
-	req.SetBasicAuth(t.user, t.pass)
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return errors.New("xray server responded with status: " + resp.Status)
-	}
-	return nil
+	return t.xray.SendKubeMetadata(ctx, payload)
 }
 
 // check if this namespace is in the whitelist for the provided violation type
-func isWhitelistedNamespace(t *HandlerImpl, pod *core_v1.Pod, rec, seciss, liciss bool) bool {
+func isWhitelistedNamespace(pols Policies, namespace string, rec, seciss, liciss bool) bool {
 	whitelist := make([]string, 0)
 	if !rec {
-		whitelist = append(whitelist, t.unscanned.whitelist...)
+		whitelist = append(whitelist, pols.unscanned.whitelist...)
 	}
 	if seciss {
-		whitelist = append(whitelist, t.security.whitelist...)
+		whitelist = append(whitelist, pols.security.whitelist...)
 	}
 	if liciss {
-		whitelist = append(whitelist, t.license.whitelist...)
+		whitelist = append(whitelist, pols.license.whitelist...)
 	}
 	for _, ns := range whitelist {
-		if ns == pod.Namespace {
+		if ns == namespace {
 			return true
 		}
 	}
@@ -461,7 +787,7 @@ func isWhitelistedNamespace(t *HandlerImpl, pod *core_v1.Pod, rec, seciss, licis
 }
 
 // send a notification to slack
-func notifyForPod(slack string, payload NotifyPayload, seciss, liciss bool) {
+func notifyForPod(slack string, payload NotifyPayload) {
 	log.Debugf("Sending notification concerning pod %s", payload.Name)
 	if slack == "" {
 		log.Warn("Unable to send notification, no Slack webhook URL configured")
@@ -473,16 +799,40 @@ func notifyForPod(slack string, payload NotifyPayload, seciss, liciss bool) {
 		msg1 = "*deleted*. "
 	} else if payload.Action == "scaledown" {
 		msg1 = "*scaled to zero*. "
+	} else if payload.Action == "suspend" {
+		msg1 = "*suspended*. "
+	} else if payload.Action == "evict" {
+		msg1 = "*evicted*. "
+	} else if payload.Action == "isolate" {
+		msg1 = "*isolated from the network*. "
+	} else if payload.Action == "label" {
+		msg1 = "*labelled as violating*. "
+	}
+	if payload.DryRun && payload.Action != "" {
+		msg1 = "would have been " + msg1 + "_(dry run)_ "
+	}
+	issues := make([]Issue, 0)
+	for _, comp := range payload.Components {
+		issues = append(issues, comp.Issues...)
 	}
+	seciss, liciss := issueFlags(issues)
 	msg2 := "_Reason: Unrecognized by Xray_\n"
 	if seciss {
-		msg2 = "_Reason: Major security issue_\n"
+		msg2 = "_Reason: " + issueSeverity(issues).String() + " security issue_\n"
 	} else if liciss {
-		msg2 = "_Reason: Major license issue_\n"
+		msg2 = "_Reason: " + issueSeverity(issues).String() + " license issue_\n"
 	}
 	msg3 := "Affected components:"
 	for _, comp := range payload.Components {
 		msg3 += "\n• " + comp.Name + " _(sha256:" + comp.Checksum + ")_"
+		if comp.Role == initContainerRole {
+			msg3 += " in an init container"
+		} else if comp.Role == ephemeralContainerRole {
+			msg3 += " in an ephemeral container"
+		}
+		if len(comp.Issues) > 0 {
+			msg3 += ": " + describeIssues(comp.Issues)
+		}
 	}
 	var js = map[string]string{
 		"username": "kube-xray",
@@ -513,323 +863,435 @@ func notifyForPod(slack string, payload NotifyPayload, seciss, liciss bool) {
 	log.Debug("Notification successful")
 }
 
-// get the parent resource name and type of a given pod
-func checkResource(client kubernetes.Interface, pod *core_v1.Pod) (string, ResourceType) {
-	subs1 := strings.LastIndexByte(pod.Name, '-')
-	if subs1 < 0 {
-		log.Debugf("Resource for pod %s is not a recognized resource type", pod.Name)
-		return "", Unrecognized
+// the roles of the containers of a pod
+const (
+	appContainerRole       = "container"
+	initContainerRole      = "init"
+	ephemeralContainerRole = "ephemeral"
+)
+
+// ContainerImage is the image of a container in a pod, with the digest it
+// runs, or will run once pulled.
+type ContainerImage struct {
+	Container string
+	Role      string
+	Image     string
+	Digest    string
+}
+
+// get the digest of an image from the id the container runtime reports for it
+func statusDigest(imageID string) string {
+	idx := strings.LastIndex(imageID, "sha256:")
+	if idx == -1 {
+		return ""
 	}
-	subs2 := strings.LastIndexByte(pod.Name[:subs1], '-')
-	sets := client.AppsV1().StatefulSets(pod.Namespace)
-	_, err := sets.Get(pod.Name[:subs1], meta_v1.GetOptions{})
-	if err == nil {
-		return pod.Name[:subs1], StatefulSet
+	return imageID[idx+7:]
+}
+
+// get the image of every container in a pod, init and ephemeral containers
+// included, with its digest. The digest is taken from the container status
+// once the image is pulled, and otherwise from the image reference or the
+// registry, so that pods which are pending or cannot start are checked as well.
+func podImages(ctx context.Context, client kubernetes.Interface, pod *core_v1.Pod) []ContainerImage {
+	digests := make(map[string]string)
+	for _, status := range pod.Status.InitContainerStatuses {
+		digests[status.Name] = statusDigest(status.ImageID)
 	}
-	log.Debugf("Resource for pod %s is not stateful set %s: %v", pod.Name, pod.Name[:subs1], err)
-	if subs2 < 0 {
-		log.Debugf("Resource for pod %s is not a recognized resource type", pod.Name)
-		return "", Unrecognized
+	for _, status := range pod.Status.ContainerStatuses {
+		digests[status.Name] = statusDigest(status.ImageID)
 	}
-	deps := client.AppsV1().Deployments(pod.Namespace)
-	_, err = deps.Get(pod.Name[:subs2], meta_v1.GetOptions{})
-	if err == nil {
-		return pod.Name[:subs2], Deployment
-	}
-	log.Debugf("Resource for pod %s is not deployment %s: %v", pod.Name, pod.Name[:subs2], err)
-	return "", Unrecognized
-}
-
-// remove a pod by either deleting it, or scaling it to zero replicas
-func removePod(client kubernetes.Interface, pod *core_v1.Pod, typ ResourceType, delete bool) {
-	deps := client.AppsV1().Deployments(pod.Namespace)
-	sets := client.AppsV1().StatefulSets(pod.Namespace)
-	subs1 := strings.LastIndexByte(pod.Name, '-')
-	subs2 := strings.LastIndexByte(pod.Name[:subs1], '-')
-	setname := pod.Name[:subs1]
-	depname := pod.Name[:subs2]
-	if delete && typ == StatefulSet {
-		log.Infof("Deleting stateful set: %s", setname)
-		err := sets.Delete(setname, &meta_v1.DeleteOptions{})
-		if err != nil {
-			log.Warnf("Cannot delete stateful set: %s", err)
-		}
-	} else if delete && typ == Deployment {
-		log.Infof("Deleting deployment: %s", depname)
-		err := deps.Delete(depname, &meta_v1.DeleteOptions{})
-		if err != nil {
-			log.Warnf("Cannot delete deployment: %s", err)
-		}
-	} else if !delete && typ == StatefulSet {
-		log.Infof("Scaling stateful set to zero pods: %s", setname)
-		set, err := sets.Get(setname, meta_v1.GetOptions{})
-		if err != nil {
-			log.Warnf("Cannot find stateful set: %s", err)
-			return
-		}
-		*set.Spec.Replicas = 0
-		_, err = sets.Update(set)
-		if err != nil {
-			log.Warnf("Cannot update stateful set: %s", err)
-		}
-	} else if !delete && typ == Deployment {
-		log.Infof("Scaling deployment to zero pods: %s", depname)
-		dep, err := deps.Get(depname, meta_v1.GetOptions{})
-		if err != nil {
-			log.Warnf("Cannot find deployment: %s", err)
-			return
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		digests[status.Name] = statusDigest(status.ImageID)
+	}
+	// only read the pull secrets if an image has to be resolved
+	var creds map[string]RegistryAuth
+	images := make([]ContainerImage, 0)
+	roles := make([]string, 0)
+	containers := make([]core_v1.Container, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers)+len(pod.Spec.EphemeralContainers))
+	for _, container := range pod.Spec.InitContainers {
+		containers = append(containers, container)
+		roles = append(roles, initContainerRole)
+	}
+	for _, container := range pod.Spec.Containers {
+		containers = append(containers, container)
+		roles = append(roles, appContainerRole)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		containers = append(containers, core_v1.Container(container.EphemeralContainerCommon))
+		roles = append(roles, ephemeralContainerRole)
+	}
+	for i, container := range containers {
+		digest := digests[container.Name]
+		if digest == "" {
+			digest = imageDigest(container.Image)
 		}
-		*dep.Spec.Replicas = 0
-		_, err = deps.Update(dep)
-		if err != nil {
-			log.Warnf("Cannot update deployment: %s", err)
+		if digest == "" {
+			if creds == nil {
+				creds = registryCredentials(ctx, client, pod)
+			}
+			resolved, err := resolveDigest(ctx, container.Image, creds)
+			if err != nil {
+				log.Debugf("Cannot resolve the digest of %s: %s", container.Image, err)
+			}
+			digest = strings.TrimPrefix(resolved, "sha256:")
 		}
-	} else {
-		log.Warnf("Unable to handle case: delete = %v, type = %v", delete, typ)
+		log.Debugf("Container: %s (%s), Digest: %s", container.Image, roles[i], digest)
+		images = append(images, ContainerImage{Container: container.Name, Role: roles[i], Image: container.Image, Digest: digest})
 	}
+	return images
 }
 
-// check a new pod against xray and extract useful information about it
-func getPodInfo(t *HandlerImpl, pod *core_v1.Pod) ([]NotifyComponentPayload, bool, bool, bool) {
-	components := make([]NotifyComponentPayload, 0)
-	recognized := true
-	hassecissue := false
-	haslicissue := false
+// check a new pod against xray and collect the scan results of its images
+func getPodInfo(ctx context.Context, t *HandlerImpl, client kubernetes.Interface, pols Policies, pod *core_v1.Pod) PodScan {
+	scan := PodScan{Results: make([]ScanResult, 0)}
 	log.Debugf("Pod: %s v.%s (Node: %s, %s)", pod.Name, pod.ObjectMeta.ResourceVersion,
 		pod.Spec.NodeName, pod.Status.Phase)
-	for _, status := range pod.Status.ContainerStatuses {
-		idx := strings.LastIndex(status.ImageID, "sha256:")
-		var sha2 string
-		if idx != -1 {
-			sha2 = status.ImageID[idx+7:]
-		} else {
-			sha2 = "NA"
-		}
-		log.Debugf("Container: %s, Digest: %s", status.Image, sha2)
-		if sha2 != "NA" && t.url != "" {
-			rec, secissue, licissue, err := checkXray(sha2, t.url, t.user, t.pass)
-			if err == nil {
-				comp := NotifyComponentPayload{Name: status.Image, Checksum: sha2}
-				components = append(components, comp)
-				recognized = recognized && rec
-				hassecissue = hassecissue || secissue
-				haslicissue = haslicissue || licissue
+	imgs := podImages(ctx, client, pod)
+	batch := t.prefetchDigests(ctx, t.imageDigests(imgs))
+	for _, img := range imgs {
+		res, err := t.scanImage(ctx, pols, batch, pod.Namespace, img.Image, img.Digest)
+		if err == nil && res != nil {
+			res.Role = img.Role
+			if res = pols.filterRole(*res); res != nil {
+				scan.Results = append(scan.Results, *res)
 			}
 		}
 	}
-	return components, recognized, hassecissue, haslicissue
+	return scan
 }
 
-// parse the config.yaml file and return its contents
-func getConfig(path, path2 string) (Policy, Policy, Policy, error) {
-	file, err := ioutil.ReadFile(path)
+// check an image with xray, unless the image policy allows or denies it
+// outright, returning nil if there is nothing to report about it
+func (t *HandlerImpl) scanImage(ctx context.Context, pols Policies, batch map[string]*cacheCall, namespace, image, sha2 string) (*ScanResult, error) {
+	if t.images.denied(image) {
+		log.Debugf("Container: %s is denied by the image policy", image)
+		return &ScanResult{Image: image, Checksum: sha2, Recognized: true, Issues: []Issue{deniedIssue}}, nil
+	}
+	if t.images.allowed(image) {
+		log.Debugf("Container: %s is allowed by the image policy", image)
+		return nil, nil
+	}
+	if sha2 == "" || t.url == "" {
+		return nil, nil
+	}
+	res, err := t.checkDigest(ctx, pols, batch, sha2)
 	if err != nil {
-		file, err = ioutil.ReadFile(path2)
-		if err != nil {
-			return Policy{}, Policy{}, Policy{}, err
+		return nil, err
+	}
+	res.Image = image
+	res.Issues = t.filterExceptions(res.Issues, namespace, image)
+	return &res, nil
+}
+
+// check a digest with xray, reusing the outcome of a batched lookup or the
+// cached result if there is one, and keep the issues at or above the
+// thresholds of the given policies unless a policy expression sees them all
+func (t *HandlerImpl) checkDigest(ctx context.Context, pols Policies, batch map[string]*cacheCall, sha2 string) (ScanResult, error) {
+	if call, ok := batch[sha2]; ok {
+		if call.err != nil {
+			return call.res, call.err
 		}
+		return call.res.atSeverity(t.thresholds(pols)), nil
 	}
-	var data map[string]Policy
-	err = yaml.Unmarshal([]byte(file), &data)
+	res, err := t.cache.get(ctx, sha2, func() (ScanResult, error) {
+		// cache every issue, as the thresholds differ between policies
+		if t.batch {
+			// the same api as batched lookups, so results do not depend on
+			// whether a digest was looked up on its own
+			return checkXrayBackup(ctx, t.xray, sha2, Unknown, Unknown)
+		}
+		return checkXray(ctx, t.xray, sha2, Unknown, Unknown)
+	})
 	if err != nil {
-		return Policy{}, Policy{}, Policy{}, err
+		return res, err
+	}
+	return res.atSeverity(t.thresholds(pols)), nil
+}
+
+// look up the given digests with xray in a single request, if batched lookups
+// are enabled, returning the outcome for the digests that were not cached
+// for checkDigest to use
+func (t *HandlerImpl) prefetchDigests(ctx context.Context, shas []string) map[string]*cacheCall {
+	if !t.batch || t.url == "" {
+		return nil
+	}
+	return t.cache.prefetch(shas, func(missing []string) (map[string]ScanResult, error) {
+		return checkXrayBatch(ctx, t.xray, missing, Unknown, Unknown)
+	})
+}
+
+// get the digests of the images that scanImage checks with xray
+func (t *HandlerImpl) imageDigests(imgs []ContainerImage) []string {
+	shas := make([]string, 0)
+	for _, img := range imgs {
+		if img.Digest != "" && !t.images.denied(img.Image) && !t.images.allowed(img.Image) {
+			shas = append(shas, img.Digest)
+		}
 	}
-	return data["unscanned"], data["security"], data["license"], nil
+	return shas
 }
 
-// parse the xray_config.yaml file and return its contents
-func getXrayConfig(path, path2 string) (string, string, string, string, string, error) {
+// read a config file, falling back to the local copy
+func readConfigFile(path, path2 string) ([]byte, error) {
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
 		file, err = ioutil.ReadFile(path2)
+	}
+	return file, err
+}
+
+// parse the contents of the config.yaml file
+func parseConfig(file []byte) (Config, error) {
+	var data Config
+	// reject unknown keys, so typos do not silently fall back to ignore
+	err := yaml.UnmarshalStrict(file, &data)
+	if err != nil {
+		return Config{}, locateError(file, err)
+	}
+	for i := range data.Namespaces {
+		err = data.Namespaces[i].init()
 		if err != nil {
-			return "", "", "", "", "", err
+			return Config{}, errors.New("namespaces entry " + strconv.Itoa(i+1) + ": " + err.Error())
 		}
 	}
-	var data map[string]string
-	err = yaml.Unmarshal([]byte(file), &data)
+	for i := range data.Exceptions {
+		err = data.Exceptions[i].init()
+		if err != nil {
+			return Config{}, errors.New("exceptions entry " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+	}
+	err = data.Images.init()
 	if err != nil {
-		return "", "", "", "", "", err
+		return Config{}, errors.New("images: " + err.Error())
 	}
-	url, urlok := data["url"]
-	user, userok := data["user"]
-	pass, passok := data["password"]
-	if urlok && userok && passok {
-		return url, user, pass, data["slackWebhookUrl"], data["xrayWebhookToken"], nil
+	if data.Expression != "" {
+		data.expression, err = compileExpression(data.Expression)
+		if err != nil {
+			return Config{}, errors.New("expression: " + err.Error())
+		}
 	}
-	return "", "", "", "", "", errors.New("xray_config.yaml does not contain required information")
+	return data, nil
 }
 
-// ComponentPayload is the component structure in ComponentAPIResponse, as well
-// as the request payload for the xray violation API.
-type ComponentPayload struct {
-	Package string `json:"package_id"`
-	Version string `json:"version"`
+// XrayConfig encodes the xray_config.yaml file.
+type XrayConfig struct {
+	URL          string
+	User         string
+	Password     string
+	SlackWebhook string
+	WebhookToken string
+	Client       xray.Options
+	Cache        CacheOptions
+	Batch        bool
 }
 
-// ComponentAPIResponse is the response from the xray component API.
-type ComponentAPIResponse struct {
-	Checksum   string             `json:"sha256"`
-	Components []ComponentPayload `json:"ids"`
+// read a duration from the xray_config.yaml file, defaulting to the given one
+// when the key is missing
+func readDuration(data map[string]string, key string, def time.Duration) (time.Duration, error) {
+	val, ok := data[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return def, errors.New("Cannot read " + key + " with value '" + val + "'.")
+	}
+	return d, nil
 }
 
-// ViolationAPIResponseItem is the item structure in a ViolationAPIResponse.
-type ViolationAPIResponseItem struct {
-	Type     string `json:"type"`
-	Severity string `json:"severity"`
+// parse the contents of the xray_config.yaml file
+func parseXrayConfig(file []byte) (XrayConfig, error) {
+	var data map[string]string
+	err := yaml.Unmarshal(file, &data)
+	if err != nil {
+		return XrayConfig{}, err
+	}
+	url, urlok := data["url"]
+	user, userok := data["user"]
+	pass, passok := data["password"]
+	if !urlok || !userok || !passok {
+		return XrayConfig{}, errors.New("xray_config.yaml does not contain required information")
+	}
+	conf := XrayConfig{URL: url, User: user, Password: pass, SlackWebhook: data["slackWebhookUrl"], WebhookToken: data["xrayWebhookToken"]}
+	conf.Client.Retries = xray.DefaultRetries
+	conf.Client.Timeout, err = readDuration(data, "timeout", xray.DefaultTimeout)
+	if err != nil {
+		return XrayConfig{}, err
+	}
+	conf.Client.Backoff, err = readDuration(data, "backoff", xray.DefaultBackoff)
+	if err != nil {
+		return XrayConfig{}, err
+	}
+	if val, ok := data["retries"]; ok {
+		conf.Client.Retries, err = strconv.Atoi(val)
+		if err != nil || conf.Client.Retries < 0 {
+			return XrayConfig{}, errors.New("Cannot read retries with value '" + val + "'.")
+		}
+	}
+	conf.Cache.TTL, err = readDuration(data, "cacheTTL", defaultCacheTTL)
+	if err != nil {
+		return XrayConfig{}, err
+	}
+	conf.Cache.UnrecognizedTTL, err = readDuration(data, "cacheUnrecognizedTTL", defaultUnrecognizedTTL)
+	if err != nil {
+		return XrayConfig{}, err
+	}
+	conf.Cache.File = data["cacheFile"]
+	if val, ok := data["batchLookups"]; ok {
+		conf.Batch, err = strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return XrayConfig{}, errors.New("Cannot read batchLookups with value '" + val + "'.")
+		}
+	}
+	return conf, nil
 }
 
-// ViolationAPIResponse is the response from the xray violation API.
-type ViolationAPIResponse struct {
-	Total int                        `json:"total_count"`
-	Data  []ViolationAPIResponseItem `json:"data"`
+// get the issue xray reported for an image, of the given type if it is at or
+// above the given severity
+func thresholdIssue(iss Issue, typ string, secmin, licmin Severity) (Issue, bool) {
+	sev := parseSeverity(iss.Severity)
+	switch typ {
+	case "security":
+		iss.Type = "security"
+		return iss, sev >= secmin
+	case "license", "licenses":
+		iss.Type = "license"
+		return iss, sev >= licmin
+	}
+	return iss, false
 }
 
 // ask xray about the checksums in a given pod, specifically for any violations
-func checkXray(sha2, url, user, pass string) (bool, bool, bool, error) {
-	apiNotFound := errors.New("404 response, try the backup API instead")
+// at or above the given security and license severities
+func checkXray(ctx context.Context, client *xray.Client, sha2 string, secmin, licmin Severity) (ScanResult, error) {
 	log.Debugf("Checking sha %s with Xray ...", sha2)
-	var data ComponentAPIResponse
-	err := func(data *ComponentAPIResponse) error {
-		client := &http.Client{}
-		req, err := http.NewRequest("GET", url+"/api/v1/componentIdsByChecksum/"+sha2, nil)
-		if err != nil {
-			log.Warnf("Error checking xray: %s", err)
-			return err
-		}
-		req.SetBasicAuth(user, pass)
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Warnf("Error checking xray: %s", err)
-			return err
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode == 404 {
-			return apiNotFound
-		}
-		if resp.StatusCode != 200 {
-			log.Warnf("Error checking xray: response code is %s", resp.Status)
-			return errors.New("xray server responded with status: " + resp.Status)
-		}
-		err = json.NewDecoder(resp.Body).Decode(data)
-		if err != nil {
-			log.Warnf("Error checking xray: %s", err)
-			return err
-		}
-		return nil
-	}(&data)
-	if err == apiNotFound {
+	result := ScanResult{Checksum: sha2}
+	data, err := client.ComponentsByChecksum(ctx, sha2)
+	if xray.IsNotFound(err) {
 		log.Debug("404 response from componentIdsByChecksum, trying backup API instead")
-		return checkXrayBackup(sha2, url, user, pass)
+		return checkXrayBackup(ctx, client, sha2, secmin, licmin)
 	}
 	if err != nil {
-		return false, false, false, err
+		log.Warnf("Error checking xray: %s", err)
+		return result, err
 	}
 	if len(data.Components) <= 0 {
 		log.Debug("Xray does not recognize this sha")
-		return false, false, false, nil
+		return result, nil
 	}
+	issues := make([]Issue, 0)
 	for _, comp := range data.Components {
-		bodyjson, err := json.Marshal(&comp)
+		resp, err := client.Violations(ctx, comp)
 		if err != nil {
 			log.Warnf("Error checking xray: %s", err)
-			return false, false, false, err
-		}
-		var resp ViolationAPIResponse
-		err = func(data *ViolationAPIResponse) error {
-			client := &http.Client{}
-			path := "/ui/userIssues/details?direction=asc&order_by=severity&num_of_rows=0&page_num=0"
-			body := bytes.NewReader(bodyjson)
-			req, err := http.NewRequest("POST", url+path, body)
-			if err != nil {
-				log.Warnf("Error checking xray: %s", err)
-				return err
-			}
-			req.SetBasicAuth(user, pass)
-			req.Header.Add("Content-Type", "application/json")
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Warnf("Error checking xray: %s", err)
-				return err
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode != 200 {
-				log.Warnf("Error checking xray: response code is %s", resp.Status)
-				return errors.New("xray server responded with status: " + resp.Status)
-			}
-			err = json.NewDecoder(resp.Body).Decode(data)
-			if err != nil {
-				log.Warnf("Error checking xray: %s", err)
-				return err
-			}
-			return nil
-		}(&resp)
-		if err != nil {
-			return false, false, false, err
+			return result, err
 		}
 		for _, item := range resp.Data {
-			if item.Severity == "High" {
-				if item.Type == "security" {
-					log.Infof("Major security violation found for sha: %s", sha2)
-					return true, true, false, nil
-				} else if item.Type == "licenses" || item.Type == "license" {
-					log.Infof("Major license violation found for sha: %s", sha2)
-					return true, false, true, nil
-				}
+			id := issueID(item.IssueID, item.Summary, item.Type)
+			iss := Issue{Severity: item.Severity, ID: id, Summary: item.Summary, CVEs: cveIDs(item.CVEs), Component: comp.Package, FixedVersions: item.FixedVersions}
+			if iss, ok := thresholdIssue(iss, item.Type, secmin, licmin); ok {
+				log.Infof("%s %s violation %s found for sha: %s", item.Severity, iss.Type, id, sha2)
+				issues = append(issues, iss)
 			}
 		}
 	}
-	log.Debug("No major security issues found")
-	return true, false, false, nil
+	if len(issues) == 0 {
+		log.Debug("No major security issues found")
+	}
+	result.Recognized = true
+	result.Issues = issues
+	return result, nil
 }
 
-// ask xray about the checksums in a given pod, specifically for any issues
-func checkXrayBackup(sha2, url, user, pass string) (bool, bool, bool, error) {
-	log.Debugf("Checking sha %s with Xray ...", sha2)
-	client := &http.Client{}
-	body := strings.NewReader("{\"checksums\":[\"" + sha2 + "\"]}")
-	req, err := http.NewRequest("POST", url+"/api/v1/summary/artifact", body)
+// get the id of an issue reported by xray, which is the name of the license
+// for license issues, and the xray issue id otherwise, falling back to the
+// summary if there is none
+func issueID(id, summary, typ string) string {
+	if summary != "" && (id == "" || typ == "license") {
+		return summary
+	}
+	return id
+}
+
+// get the ids of the cves of an issue reported by xray
+func cveIDs(cves []xray.CVE) []string {
+	ids := make([]string, 0, len(cves))
+	for _, c := range cves {
+		if c.CVE != "" {
+			ids = append(ids, c.CVE)
+		}
+	}
+	return ids
+}
+
+// get the scan result of an artifact from the artifact summary API, keeping
+// the issues at or above the given security and license severities
+func artifactResult(art xray.Artifact, sha2 string, secmin, licmin Severity) ScanResult {
+	result := ScanResult{Checksum: sha2, Recognized: true, Issues: make([]Issue, 0)}
+	for _, is := range art.Issues {
+		id := issueID(is.IssueID, is.Summary, is.IssueType)
+		cves := cveIDs(is.CVEs)
+		// the first impacted component, and the versions fixing any of them
+		component := ""
+		fixed := make([]string, 0)
+		for _, comp := range is.Components {
+			if component == "" {
+				component = comp.ComponentID
+			}
+			fixed = append(fixed, comp.FixedVersions...)
+		}
+		iss := Issue{Severity: is.Severity, ID: id, Summary: is.Summary, CVEs: cves, Component: component, FixedVersions: fixed}
+		if iss, ok := thresholdIssue(iss, is.IssueType, secmin, licmin); ok {
+			log.Infof("%s %s issue %s found for sha: %s", is.Severity, iss.Type, id, sha2)
+			result.Issues = append(result.Issues, iss)
+		}
+	}
+	return result
+}
+
+// ask xray about several checksums in a single request, specifically for any
+// issues at or above the given security and license severities; checksums
+// xray does not know are missing from the results
+func checkXrayBatch(ctx context.Context, client *xray.Client, shas []string, secmin, licmin Severity) (map[string]ScanResult, error) {
+	log.Debugf("Checking %d shas with Xray ...", len(shas))
+	data, err := client.ArtifactSummary(ctx, shas)
 	if err != nil {
 		log.Warnf("Error checking xray: %s", err)
-		return false, false, false, err
+		return nil, err
 	}
-	req.SetBasicAuth(user, pass)
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := client.Do(req)
+	results := make(map[string]ScanResult)
+	for _, art := range data.Artifacts {
+		sha2 := strings.TrimPrefix(art.General.Checksum, "sha256:")
+		if sha2 == "" && len(shas) == 1 {
+			sha2 = shas[0]
+		}
+		if sha2 == "" {
+			log.Debugf("Ignoring xray artifact %s without a checksum", art.General.Name)
+			continue
+		}
+		res := artifactResult(art, sha2, secmin, licmin)
+		if prev, ok := results[sha2]; ok {
+			res.Issues = append(prev.Issues, res.Issues...)
+		}
+		results[sha2] = res
+	}
+	return results, nil
+}
+
+// ask xray about the checksums in a given pod, specifically for any issues at
+// or above the given security and license severities
+func checkXrayBackup(ctx context.Context, client *xray.Client, sha2 string, secmin, licmin Severity) (ScanResult, error) {
+	results, err := checkXrayBatch(ctx, client, []string{sha2}, secmin, licmin)
 	if err != nil {
-		log.Warnf("Error checking xray: %s", err)
-		return false, false, false, err
+		return ScanResult{Checksum: sha2}, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		log.Warnf("Error checking xray: response code is %s", resp.Status)
-		return false, false, false, errors.New("xray server responded with status: " + resp.Status)
-	}
-	var data interface{}
-	json.NewDecoder(resp.Body).Decode(&data)
-	dt := data.(map[string]interface{})
-	artifacts := dt["artifacts"].([]interface{})
-	if len(artifacts) <= 0 {
+	result, ok := results[sha2]
+	if !ok {
 		log.Debug("Xray does not recognize this sha")
-		return false, false, false, nil
-	}
-	for _, artifact := range artifacts {
-		art := artifact.(map[string]interface{})
-		issues := art["issues"].([]interface{})
-		for _, issue := range issues {
-			is := issue.(map[string]interface{})
-			typ := is["issue_type"].(string)
-			sev := is["severity"].(string)
-			if typ == "security" && (sev == "Major" || sev == "Critical" || sev == "High") {
-				log.Infof("Major security issue found for sha: %s", sha2)
-				return true, true, false, nil
-			}
-			if typ == "license" && (sev == "Major" || sev == "Critical" || sev == "High") {
-				log.Infof("Major license issue found for sha: %s", sha2)
-				return true, false, true, nil
-			}
-		}
+		return ScanResult{Checksum: sha2}, nil
 	}
-	log.Debug("No major security issues found")
-	return true, false, false, nil
+	if len(result.Issues) == 0 {
+		log.Debug("No major security issues found")
+	}
+	return result, nil
 }
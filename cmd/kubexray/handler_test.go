@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfrog/kubexray/xray"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	vulnerableDigest = "1111111111111111111111111111111111111111111111111111111111111111"
+	unknownDigest    = "2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// serve the artifact summary api of an xray that knows a single vulnerable
+// image, failing the batches the given function rejects, and record the
+// checksums of every request
+func batchXray(t *testing.T, fail func(checksums []string) bool) (*httptest.Server, func() [][]string) {
+	var mu sync.Mutex
+	requests := make([][]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		var data xray.ArtifactSummaryRequest
+		if req.URL.Path != "/api/v1/summary/artifact" || json.NewDecoder(req.Body).Decode(&data) != nil {
+			resp.WriteHeader(400)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, data.Checksums)
+		mu.Unlock()
+		if fail(data.Checksums) {
+			resp.WriteHeader(500)
+			return
+		}
+		summary := xray.ArtifactSummaryResponse{Artifacts: make([]xray.Artifact, 0)}
+		for _, sha2 := range data.Checksums {
+			if sha2 == vulnerableDigest {
+				summary.Artifacts = append(summary.Artifacts, xray.Artifact{
+					General: xray.ArtifactGeneral{Name: "web", Checksum: "sha256:" + sha2},
+					Issues:  []xray.ArtifactIssue{{IssueID: "XRAY-1", IssueType: "security", Severity: "High"}},
+				})
+			}
+		}
+		json.NewEncoder(resp).Encode(summary)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestGetPodInfoBatch(t *testing.T) {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: core_v1.PodSpec{Containers: []core_v1.Container{
+			{Name: "web", Image: "web@sha256:" + vulnerableDigest},
+			{Name: "sidecar", Image: "sidecar@sha256:" + unknownDigest},
+		}},
+	}
+	never := func([]string) bool { return false }
+	batches := func(checksums []string) bool { return len(checksums) > 1 }
+	cached := CacheOptions{TTL: time.Minute, UnrecognizedTTL: time.Minute}
+	tests := []struct {
+		name         string
+		fail         func([]string) bool
+		cache        CacheOptions
+		wantRequests []int
+		wantResults  int
+	}{
+		{"batched", never, cached, []int{2}, 2},
+		{"cache disabled", never, CacheOptions{}, []int{2}, 2},
+		// a failed batch is not retried digest by digest
+		{"failed batch", batches, cached, []int{2}, 0},
+	}
+	for _, tt := range tests {
+		srv, requests := batchXray(t, tt.fail)
+		handler := &HandlerImpl{
+			url:   srv.URL,
+			xray:  xray.NewClient(srv.URL, "", "", xray.Options{}),
+			cache: newScanCache(tt.cache),
+			batch: true,
+		}
+		scan := getPodInfo(context.Background(), handler, fake.NewSimpleClientset(), Policies{}, pod)
+		got := make([]int, 0)
+		for _, req := range requests() {
+			got = append(got, len(req))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.wantRequests) {
+			t.Errorf("%s: requests of %v checksums, want %v", tt.name, got, tt.wantRequests)
+		}
+		if len(scan.Results) != tt.wantResults {
+			t.Errorf("%s: %d results, want %d", tt.name, len(scan.Results), tt.wantResults)
+			continue
+		}
+		for _, res := range scan.Results {
+			vulnerable := res.Checksum == vulnerableDigest
+			if res.Recognized != vulnerable || (len(res.Issues) == 1) != vulnerable {
+				t.Errorf("%s: result %+v", tt.name, res)
+			}
+		}
+	}
+}
+
+// a running pod in the default namespace with a single pulled image
+func runningPod(name, image, sha2 string) *core_v1.Pod {
+	return &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+		Status: core_v1.PodStatus{ContainerStatuses: []core_v1.ContainerStatus{
+			{Name: "app", Image: image, ImageID: "docker-pullable://" + image + "@sha256:" + sha2},
+		}},
+	}
+}
+
+func TestSearchChecksums(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "default"}},
+		runningPod("web-1", "web:1", vulnerableDigest),
+		runningPod("web-2", "web:1", vulnerableDigest),
+		runningPod("sidecar", "sidecar:1", unknownDigest),
+		&core_v1.Pod{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "debug", Namespace: "default"},
+			Status: core_v1.PodStatus{
+				InitContainerStatuses: []core_v1.ContainerStatus{
+					{Name: "init", Image: "web:1", ImageID: "docker-pullable://web@sha256:" + vulnerableDigest},
+				},
+				EphemeralContainerStatuses: []core_v1.ContainerStatus{
+					{Name: "debugger", Image: "web:1", ImageID: "docker-pullable://web@sha256:" + vulnerableDigest},
+				},
+			},
+		},
+	)
+	matches, err := searchChecksums(client, []searchItem{{sha2: vulnerableDigest, isstype: "security"}})
+	if err != nil {
+		t.Fatalf("searchChecksums: %v", err)
+	}
+	// each match points at the pod and container running the image
+	want := map[string]bool{"web-1/container": true, "web-2/container": true, "debug/init": true, "debug/ephemeral": true}
+	if len(matches) != len(want) {
+		t.Fatalf("searchChecksums = %+v, want %d matches", matches, len(want))
+	}
+	for _, match := range matches {
+		if !want[match.pod.Name+"/"+match.role] || match.name != "web:1" || match.isstype != "security" {
+			t.Errorf("match %+v", match)
+		}
+		delete(want, match.pod.Name+"/"+match.role)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		configured string
+		sent       string
+		want       bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "guess", false},
+		{"secret", "", false},
+		// no token configured, as after a reload dropped it
+		{"", "", false},
+		{"", "guess", false},
+	}
+	for _, tt := range tests {
+		handler := &HandlerImpl{webhookToken: tt.configured}
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if tt.sent != "" {
+			req.Header.Set("X-Auth-Token", tt.sent)
+		}
+		if got := handler.authorized(req); got != tt.want {
+			t.Errorf("authorized(%q) with token %q = %v, want %v", tt.sent, tt.configured, got, tt.want)
+		}
+	}
+}
+
+func TestIssueID(t *testing.T) {
+	tests := []struct {
+		id, summary, typ string
+		want             string
+	}{
+		{"XRAY-1", "openssl overflow", "security", "XRAY-1"},
+		{"", "openssl overflow", "security", "openssl overflow"},
+		// exceptions name licenses, not their xray issue ids
+		{"XRAY-2", "GPL-3.0", "license", "GPL-3.0"},
+		{"XRAY-2", "", "license", "XRAY-2"},
+	}
+	for _, tt := range tests {
+		if got := issueID(tt.id, tt.summary, tt.typ); got != tt.want {
+			t.Errorf("issueID(%q, %q, %q) = %q, want %q", tt.id, tt.summary, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestReadAction(t *testing.T) {
+	tests := []struct {
+		key     string
+		val     string
+		want    Action
+		wantErr bool
+	}{
+		{"deployments", "scaledown", Scaledown, false},
+		{"pods", "scaledown", Ignore, true},
+		{"cronJobs", "suspend", Suspend, false},
+		{"jobs", "suspend", Ignore, true},
+		{"pods", "evict", Evict, false},
+		{"deployments", "evict", Ignore, true},
+		{"pods", "delete", Delete, false},
+		{"pods", "isolate", Isolate, false},
+		{"replicaSets", "label", Label, false},
+		{"deployments", "bogus", Ignore, true},
+	}
+	for _, tt := range tests {
+		got, err := readAction(map[string]interface{}{tt.key: tt.val}, tt.key, true)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("readAction(%s: %s) = %s, %v, want %s, error %v", tt.key, tt.val, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseXrayConfigClient(t *testing.T) {
+	base := "url: https://xray.example.com\nuser: admin\npassword: password\n"
+	tests := []struct {
+		name    string
+		extra   string
+		want    xray.Options
+		wantErr bool
+	}{
+		{"defaults", "", xray.Options{Timeout: xray.DefaultTimeout, Retries: xray.DefaultRetries, Backoff: xray.DefaultBackoff}, false},
+		{"configured", "timeout: 10s\nretries: 5\nbackoff: 2s\n", xray.Options{Timeout: 10 * time.Second, Retries: 5, Backoff: 2 * time.Second}, false},
+		{"invalid backoff", "backoff: soon\n", xray.Options{}, true},
+		{"negative backoff", "backoff: -1s\n", xray.Options{}, true},
+	}
+	for _, tt := range tests {
+		conf, err := parseXrayConfig([]byte(base + tt.extra))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && conf.Client != tt.want {
+			t.Errorf("%s: client options = %+v, want %+v", tt.name, conf.Client, tt.want)
+		}
+	}
+}
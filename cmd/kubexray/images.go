@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// prefix marking an image pattern as a regular expression rather than a glob
+const regexPrefix = "regex:"
+
+// ImagePolicy encodes the images section of the config.yaml file, listing
+// the images that are always allowed (and so never checked with xray) or
+// always denied (and so treated as having a security issue), by glob
+// pattern or regular expression.
+type ImagePolicy struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// compile an image pattern, which is either a glob pattern or a regular
+// expression prefixed with "regex:"
+func compileImagePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		return regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+	}
+	return regexp.Compile(globExpr(pattern))
+}
+
+// compile the patterns of the allow and deny lists
+func (x *ImagePolicy) init() error {
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		exprs := make([]*regexp.Regexp, 0, len(patterns))
+		for _, pattern := range patterns {
+			expr, err := compileImagePattern(pattern)
+			if err != nil {
+				return nil, errors.New("Cannot read image pattern '" + pattern + "': " + err.Error())
+			}
+			exprs = append(exprs, expr)
+		}
+		return exprs, nil
+	}
+	var err error
+	x.allow, err = compile(x.Allow)
+	if err != nil {
+		return err
+	}
+	x.deny, err = compile(x.Deny)
+	return err
+}
+
+// get an image reference without the digest it is pinned to, if any
+func unpinnedImage(image string) string {
+	if idx := strings.Index(image, "@"); idx != -1 {
+		return image[:idx]
+	}
+	return image
+}
+
+// check whether an image matches any of the given patterns, either as given
+// or without its digest, so that pinning an image to its digest does not
+// change the tag patterns it matches
+func matchImage(exprs []*regexp.Regexp, image string) bool {
+	unpinned := unpinnedImage(image)
+	for _, expr := range exprs {
+		if expr.MatchString(image) || expr.MatchString(unpinned) {
+			return true
+		}
+	}
+	return false
+}
+
+// check whether an image is always denied; the deny list takes precedence
+// over the allow list
+func (x *ImagePolicy) denied(image string) bool {
+	return matchImage(x.deny, image)
+}
+
+// check whether an image is always allowed
+func (x *ImagePolicy) allowed(image string) bool {
+	return !x.denied(image) && matchImage(x.allow, image)
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestImagePolicy(t *testing.T) {
+	pol := ImagePolicy{
+		Allow: []string{"gcr.io/distroless/*", "regex:^internal\\.example\\.com/"},
+		Deny:  []string{"docker.io/*:latest", "regex:^[^/]+:latest$", "internal.example.com/legacy/*"},
+	}
+	if err := pol.init(); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	pinned := "@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		image   string
+		denied  bool
+		allowed bool
+	}{
+		{"nginx:latest", true, false},
+		{"nginx:latest" + pinned, true, false},
+		{"docker.io/library/nginx:latest", true, false},
+		{"docker.io/library/nginx:latest" + pinned, true, false},
+		{"nginx:1.17", false, false},
+		{"gcr.io/distroless/base:nonroot", false, true},
+		{"gcr.io/distroless/base:nonroot" + pinned, false, true},
+		{"internal.example.com/app:v1", false, true},
+		{"internal.example.com/legacy/app:v1", true, false},
+	}
+	for _, tt := range tests {
+		if got := pol.denied(tt.image); got != tt.denied {
+			t.Errorf("denied(%q) = %v, want %v", tt.image, got, tt.denied)
+		}
+		if got := pol.allowed(tt.image); got != tt.allowed {
+			t.Errorf("allowed(%q) = %v, want %v", tt.image, got, tt.allowed)
+		}
+	}
+}
+
+func TestImagePolicyInvalidPattern(t *testing.T) {
+	pol := ImagePolicy{Deny: []string{"regex:(unclosed"}}
+	if err := pol.init(); err == nil {
+		t.Error("init accepted an invalid regular expression")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	apps_v1 "k8s.io/api/apps/v1"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// prefix of the names of network policies created to isolate workloads
+	isolationPrefix = "kubexray-isolate-"
+	// label marking the network policies managed by kubexray
+	managedByLabel = "app.kubernetes.io/managed-by"
+	// prefix of the labels kubexray sets on pods, which must not be used to
+	// select them as kubexray may remove them again
+	kubexrayLabelPrefix = "kubexray.io/"
+)
+
+// pod labels that differ between the pods of a workload, and so must not be
+// used to select all of them
+var volatileLabels = []string{
+	"pod-template-hash",
+	"controller-revision-hash",
+	"pod-template-generation",
+	"statefulset.kubernetes.io/pod-name",
+	"controller-uid",
+	"job-name",
+	"batch.kubernetes.io/controller-uid",
+	"batch.kubernetes.io/job-name",
+}
+
+// get the name of the network policy isolating the given owner, which
+// includes its kind so that workloads of different kinds sharing a name do
+// not share a policy
+func isolationName(owner Owner) string {
+	name := isolationPrefix + strings.ToLower(resourceKinds[owner.Type].Kind) + "-" + owner.Name
+	// a name must not exceed 253 characters nor end in a separator
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], "-.")
+	}
+	return name
+}
+
+// get the labels selecting all pods of the owner of the given pod, when the
+// owner has no selector of its own
+func isolationLabels(pod *core_v1.Pod) map[string]string {
+	labels := make(map[string]string)
+	for key, val := range pod.Labels {
+		if !strings.HasPrefix(key, kubexrayLabelPrefix) {
+			labels[key] = val
+		}
+	}
+	for _, key := range volatileLabels {
+		delete(labels, key)
+	}
+	return labels
+}
+
+// get the selector of all pods of an owner, which is the owner's own selector
+// for the workloads that have one, and otherwise the labels of the given pod
+func isolationSelector(client kubernetes.Interface, pod *core_v1.Pod, owner Owner) (*meta_v1.LabelSelector, error) {
+	if owner.Type == Pod || owner.Type == CronJob {
+		return &meta_v1.LabelSelector{MatchLabels: isolationLabels(pod)}, nil
+	}
+	obj, _, err := getObject(client, owner.Namespace, resourceKinds[owner.Type].Kind, owner.Name)
+	if err != nil {
+		return nil, err
+	}
+	switch o := obj.(type) {
+	case *apps_v1.Deployment:
+		return o.Spec.Selector, nil
+	case *apps_v1.StatefulSet:
+		return o.Spec.Selector, nil
+	case *apps_v1.DaemonSet:
+		return o.Spec.Selector, nil
+	case *apps_v1.ReplicaSet:
+		return o.Spec.Selector, nil
+	case *batch_v1.Job:
+		return o.Spec.Selector, nil
+	case *core_v1.ReplicationController:
+		return &meta_v1.LabelSelector{MatchLabels: o.Spec.Selector}, nil
+	}
+	return nil, errors.New("cannot read the selector of " + owner.Type.String() + " " + owner.Name)
+}
+
+// cut a workload off from all network traffic by creating a deny-all network
+// policy selecting its pods
+func isolateOwner(client kubernetes.Interface, pod *core_v1.Pod, owner Owner, comps []NotifyComponentPayload) error {
+	// the policy is owned by the workload, so that it is removed along with it
+	if owner.Type == Unrecognized {
+		return errors.New("the owner of pod " + pod.Name + " is not recognized")
+	}
+	selector, err := isolationSelector(client, pod, owner)
+	if err != nil {
+		return err
+	}
+	// an empty selector would isolate every pod in the namespace
+	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
+		return errors.New("pod " + pod.Name + " has no labels to select it by")
+	}
+	pols := client.NetworkingV1().NetworkPolicies(owner.Namespace)
+	pol := &networking_v1.NetworkPolicy{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:            isolationName(owner),
+			Namespace:       owner.Namespace,
+			Labels:          map[string]string{managedByLabel: "kubexray"},
+			OwnerReferences: []meta_v1.OwnerReference{ownerReference(owner)},
+		},
+		Spec: networking_v1.NetworkPolicySpec{
+			PodSelector: *selector,
+			PolicyTypes: []networking_v1.PolicyType{networking_v1.PolicyTypeIngress, networking_v1.PolicyTypeEgress},
+		},
+	}
+	markQuarantined(&pol.ObjectMeta, nil, comps)
+	_, err = pols.Create(context.TODO(), pol, meta_v1.CreateOptions{})
+	if !k8s_errors.IsAlreadyExists(err) {
+		return err
+	}
+	// already isolated, so just record any new offending digests
+	pol, err = pols.Get(context.TODO(), isolationName(owner), meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	markQuarantined(&pol.ObjectMeta, nil, comps)
+	_, err = pols.Update(context.TODO(), pol, meta_v1.UpdateOptions{})
+	return err
+}
+
+// check whether a network policy managed by kubexray isolates the given owner
+func isolates(pol *networking_v1.NetworkPolicy, owner Owner) bool {
+	if len(pol.OwnerReferences) == 0 {
+		return false
+	}
+	ref := pol.OwnerReferences[0]
+	return ref.Kind == resourceKinds[owner.Type].Kind && ref.Name == owner.Name
+}
+
+// lift the network isolation of a workload, returning false if it was not
+// isolated; the policy is found by its owner, as policies created by earlier
+// versions of kubexray are named without the kind of the workload
+func unisolateOwner(client kubernetes.Interface, owner Owner) (bool, error) {
+	opts := meta_v1.ListOptions{LabelSelector: managedByLabel + "=kubexray"}
+	pols, err := client.NetworkingV1().NetworkPolicies(owner.Namespace).List(context.TODO(), opts)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for i := range pols.Items {
+		pol := &pols.Items[i]
+		if !isolates(pol, owner) {
+			continue
+		}
+		err := client.NetworkingV1().NetworkPolicies(owner.Namespace).Delete(context.TODO(), pol.Name, meta_v1.DeleteOptions{})
+		if err != nil && !k8s_errors.IsNotFound(err) {
+			return found, err
+		}
+		found = found || err == nil
+	}
+	return found, nil
+}
+
+// re-check every isolated workload and lift the isolation of those that are
+// now clean
+func recoverIsolated(ctx context.Context, t *HandlerImpl, client kubernetes.Interface) {
+	opts := meta_v1.ListOptions{LabelSelector: managedByLabel + "=kubexray"}
+	pols, err := client.NetworkingV1().NetworkPolicies(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		log.Warnf("Cannot list network policies: %s", err)
+		return
+	}
+	for _, pol := range pols.Items {
+		if len(pol.OwnerReferences) == 0 {
+			continue
+		}
+		ref := pol.OwnerReferences[0]
+		owner := Owner{Type: kindType(ref.Kind), Name: ref.Name, Namespace: pol.Namespace, UID: ref.UID}
+		pols := applyOverrides(client, t.policiesFor(client, owner.Namespace), nil, owner)
+		if isStillViolating(ctx, t, client, pols, owner, pol.Annotations[quarantineAnnotation], pol.Annotations[quarantineImagesAnnotation]) {
+			continue
+		}
+		if t.dryRun {
+			log.Infof("Dry run: would lift network isolation of recovered %s %s in %s", owner.Type, owner.Name, owner.Namespace)
+			continue
+		}
+		log.Infof("Lifting network isolation of recovered %s: %s", owner.Type, owner.Name)
+		err := client.NetworkingV1().NetworkPolicies(pol.Namespace).Delete(context.TODO(), pol.Name, meta_v1.DeleteOptions{})
+		if err != nil && !k8s_errors.IsNotFound(err) {
+			log.Warnf("Cannot delete network policy: %s", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIsolateOwner(t *testing.T) {
+	selector := &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	meta := func(name string) meta_v1.ObjectMeta {
+		return meta_v1.ObjectMeta{Name: name, Namespace: "default", UID: "owner-uid"}
+	}
+	long := strings.Repeat("a", 224) + "." + strings.Repeat("b", 28)
+	tests := []struct {
+		typ      ResourceType
+		name     string
+		object   runtime.Object
+		wantName string
+	}{
+		{Deployment, "web", &apps_v1.Deployment{ObjectMeta: meta("web"), Spec: apps_v1.DeploymentSpec{Selector: selector}}, "kubexray-isolate-deployment-web"},
+		{StatefulSet, "web", &apps_v1.StatefulSet{ObjectMeta: meta("web"), Spec: apps_v1.StatefulSetSpec{Selector: selector}}, "kubexray-isolate-statefulset-web"},
+		{DaemonSet, "web", &apps_v1.DaemonSet{ObjectMeta: meta("web"), Spec: apps_v1.DaemonSetSpec{Selector: selector}}, "kubexray-isolate-daemonset-web"},
+		{Job, "web", &batch_v1.Job{ObjectMeta: meta("web"), Spec: batch_v1.JobSpec{Selector: selector}}, "kubexray-isolate-job-web"},
+		{CronJob, "web", &batch_v1.CronJob{ObjectMeta: meta("web")}, "kubexray-isolate-cronjob-web"},
+		{Pod, "web", nil, "kubexray-isolate-pod-web"},
+		{ReplicaSet, "web", &apps_v1.ReplicaSet{ObjectMeta: meta("web"), Spec: apps_v1.ReplicaSetSpec{Selector: selector}}, "kubexray-isolate-replicaset-web"},
+		{ReplicationController, "web", &core_v1.ReplicationController{ObjectMeta: meta("web"), Spec: core_v1.ReplicationControllerSpec{Selector: selector.MatchLabels}}, "kubexray-isolate-replicationcontroller-web"},
+		// cut to 253 characters, which would end in the dot
+		{Deployment, long, &apps_v1.Deployment{ObjectMeta: meta(long), Spec: apps_v1.DeploymentSpec{Selector: selector}}, ("kubexray-isolate-deployment-" + long)[:252]},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset()
+		if tt.object != nil {
+			client = fake.NewSimpleClientset(tt.object)
+		}
+		pod := &core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{
+			Name:      "web-x7k2p",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "web", "pod-template-hash": "5d4f", "kubexray.io/violation": "security"},
+		}}
+		owner := Owner{Type: tt.typ, Name: tt.name, Namespace: "default", UID: "owner-uid"}
+		if err := isolateOwner(client, pod, owner, []NotifyComponentPayload{{Name: "web:1", Checksum: "abc"}}); err != nil {
+			t.Errorf("%s: isolateOwner: %v", tt.typ, err)
+			continue
+		}
+		pols, err := client.NetworkingV1().NetworkPolicies("default").List(context.TODO(), meta_v1.ListOptions{})
+		if err != nil || len(pols.Items) != 1 {
+			t.Errorf("%s: network policies %v, %v", tt.typ, pols, err)
+			continue
+		}
+		pol := pols.Items[0]
+		if pol.Name != tt.wantName {
+			t.Errorf("%s: policy named %q, want %q", tt.typ, pol.Name, tt.wantName)
+		}
+		if errs := validation.IsDNS1123Subdomain(pol.Name); len(errs) > 0 {
+			t.Errorf("%s: invalid policy name %q: %v", tt.typ, pol.Name, errs)
+		}
+		if labels := pol.Spec.PodSelector.MatchLabels; len(labels) != 1 || labels["app"] != "web" {
+			t.Errorf("%s: policy selects %v", tt.typ, pol.Spec.PodSelector)
+		}
+		if !isolates(&pol, owner) {
+			t.Errorf("%s: policy owned by %v", tt.typ, pol.OwnerReferences)
+		}
+		found, err := unisolateOwner(client, owner)
+		if !found || err != nil {
+			t.Errorf("%s: unisolateOwner = %v, %v", tt.typ, found, err)
+		}
+	}
+}
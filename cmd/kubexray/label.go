@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// label holding the most important violation type of a workload
+	violationLabel = "kubexray.io/violation"
+	// label holding the severity of the violation, if known
+	severityLabel = "kubexray.io/severity"
+	// annotation listing every violation type of a workload
+	violationsAnnotation = "kubexray.io/violations"
+	// annotation listing the digests that violate the policies
+	violationDigestsAnnotation = "kubexray.io/violation-digests"
+	// annotation mapping those digests to their images, as digest=image
+	// pairs, so that exceptions for an image apply when re-checking
+	violationImagesAnnotation = "kubexray.io/violation-images"
+)
+
+// Violation describes why a pod is in violation of the policies.
+type Violation struct {
+	Types    []string
+	Severity string
+}
+
+// get the violation for the given scan results, most important type first
+func getViolation(rec, seciss, liciss bool, severity string) Violation {
+	typs := make([]string, 0)
+	if seciss {
+		typs = append(typs, "security")
+	}
+	if liciss {
+		typs = append(typs, "license")
+	}
+	if !rec {
+		typs = append(typs, "unscanned")
+	}
+	return Violation{Types: typs, Severity: severity}
+}
+
+// apply a json merge patch to the metadata of the given object
+func patchObject(client kubernetes.Interface, owner Owner, patch []byte) error {
+	var err error
+	pt := types.MergePatchType
+	switch owner.Type {
+	case StatefulSet:
+		_, err = client.AppsV1().StatefulSets(owner.Namespace).Patch(context.TODO(), owner.Name, pt, patch, meta_v1.PatchOptions{})
+	case Deployment:
+		_, err = client.AppsV1().Deployments(owner.Namespace).Patch(context.TODO(), owner.Name, pt, patch, meta_v1.PatchOptions{})
+	case DaemonSet:
+		_, err = client.AppsV1().DaemonSets(owner.Namespace).Patch(context.TODO(), owner.Name, pt, patch, meta_v1.PatchOptions{})
+	case Job:
+		_, err = client.BatchV1().Jobs(owner.Namespace).Patch(context.TODO(), owner.Name, pt, patch, meta_v1.PatchOptions{})
+	case CronJob:
+		_, err = client.BatchV1().CronJobs(owner.Namespace).Patch(context.TODO(), owner.Name, pt, patch, meta_v1.PatchOptions{})
+	case Pod:
+		_, err = client.CoreV1().Pods(owner.Namespace).Patch(context.TODO(), owner.Name, pt, patch, meta_v1.PatchOptions{})
+	case ReplicaSet:
+		_, err = client.AppsV1().ReplicaSets(owner.Namespace).Patch(context.TODO(), owner.Name, pt, patch, meta_v1.PatchOptions{})
+	case ReplicationController:
+		_, err = client.CoreV1().ReplicationControllers(owner.Namespace).Patch(context.TODO(), owner.Name, pt, patch, meta_v1.PatchOptions{})
+	default:
+		err = errors.New("cannot patch " + owner.Type.String())
+	}
+	return err
+}
+
+// stamp an object with the labels and annotations describing its violation
+func labelObject(client kubernetes.Interface, owner Owner, violation Violation, comps []NotifyComponentPayload) error {
+	obj, _, err := getObject(client, owner.Namespace, resourceKinds[owner.Type].Kind, owner.Name)
+	if err != nil {
+		return err
+	}
+	if obj.GetUID() != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	labels := map[string]interface{}{violationLabel: nil, severityLabel: nil}
+	if len(violation.Types) > 0 {
+		labels[violationLabel] = violation.Types[0]
+	}
+	if violation.Severity != "" {
+		labels[severityLabel] = violation.Severity
+	}
+	annotations := map[string]interface{}{
+		violationsAnnotation:       strings.Join(violation.Types, ","),
+		violationDigestsAnnotation: mergeDigests(obj.GetAnnotations()[violationDigestsAnnotation], comps),
+		violationImagesAnnotation:  mergeImages(obj.GetAnnotations()[violationImagesAnnotation], comps),
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels, "annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	return patchObject(client, owner, patch)
+}
+
+// stamp a workload and its offending pod with the labels and annotations
+// describing their violation, without otherwise touching them
+func labelOwner(client kubernetes.Interface, pod *core_v1.Pod, owner Owner, violation Violation, comps []NotifyComponentPayload) error {
+	if owner.Type != Unrecognized {
+		err := labelObject(client, owner, violation, comps)
+		if err != nil {
+			return err
+		}
+	}
+	if owner.Type == Pod {
+		return nil
+	}
+	log.Debugf("Labelling pod: %s", pod.Name)
+	self := Owner{Type: Pod, Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID}
+	return labelObject(client, self, violation, comps)
+}
+
+// remove the labels and annotations describing a violation from an object
+func unlabelObject(client kubernetes.Interface, owner Owner) error {
+	obj, _, err := getObject(client, owner.Namespace, resourceKinds[owner.Type].Kind, owner.Name)
+	if err != nil {
+		return err
+	}
+	if obj.GetUID() != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{violationLabel: nil, severityLabel: nil},
+			"annotations": map[string]interface{}{
+				violationsAnnotation:       nil,
+				violationDigestsAnnotation: nil,
+				violationImagesAnnotation:  nil,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return patchObject(client, owner, patch)
+}
+
+// find every object labelled as violating, along with its annotations
+// recording the offending digests
+func listLabelled(client kubernetes.Interface) (map[Owner]map[string]string, error) {
+	result := make(map[Owner]map[string]string)
+	add := func(typ ResourceType, meta meta_v1.ObjectMeta) {
+		result[Owner{Type: typ, Name: meta.Name, Namespace: meta.Namespace, UID: meta.UID}] = meta.Annotations
+	}
+	opts := meta_v1.ListOptions{LabelSelector: violationLabel}
+	deps, err := client.AppsV1().Deployments(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range deps.Items {
+		add(Deployment, dep.ObjectMeta)
+	}
+	sets, err := client.AppsV1().StatefulSets(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range sets.Items {
+		add(StatefulSet, set.ObjectMeta)
+	}
+	dsets, err := client.AppsV1().DaemonSets(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, dset := range dsets.Items {
+		add(DaemonSet, dset.ObjectMeta)
+	}
+	jobs, err := client.BatchV1().Jobs(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs.Items {
+		add(Job, job.ObjectMeta)
+	}
+	cjobs, err := client.BatchV1().CronJobs(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, cjob := range cjobs.Items {
+		add(CronJob, cjob.ObjectMeta)
+	}
+	rsets, err := client.AppsV1().ReplicaSets(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, rset := range rsets.Items {
+		add(ReplicaSet, rset.ObjectMeta)
+	}
+	rcs, err := client.CoreV1().ReplicationControllers(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, rc := range rcs.Items {
+		add(ReplicationController, rc.ObjectMeta)
+	}
+	pods, err := client.CoreV1().Pods(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		add(Pod, pod.ObjectMeta)
+	}
+	return result, nil
+}
+
+// re-check every object labelled as violating and clear the labels of those
+// that are now clean or excepted
+func recoverLabelled(ctx context.Context, t *HandlerImpl, client kubernetes.Interface) {
+	labelled, err := listLabelled(client)
+	if err != nil {
+		log.Warnf("Cannot list labelled workloads: %s", err)
+		return
+	}
+	for owner, annotations := range labelled {
+		// a pod labelled along with its workload is judged by the
+		// workload's policies
+		workload := owner
+		var pod *core_v1.Pod
+		if owner.Type == Pod {
+			pod, err = client.CoreV1().Pods(owner.Namespace).Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+			if err != nil {
+				log.Warnf("Cannot get labelled pod %s: %s", owner.Name, err)
+				continue
+			}
+			workload = checkResource(client, pod)
+		}
+		pols := applyOverrides(client, t.policiesFor(client, owner.Namespace), pod, workload)
+		if isStillViolating(ctx, t, client, pols, workload, annotations[violationDigestsAnnotation], annotations[violationImagesAnnotation]) {
+			continue
+		}
+		if t.dryRun {
+			log.Infof("Dry run: would clear violation labels of recovered %s %s in %s", owner.Type, owner.Name, owner.Namespace)
+			continue
+		}
+		log.Infof("Clearing violation labels of recovered %s: %s", owner.Type, owner.Name)
+		err := unlabelObject(client, owner)
+		if err != nil {
+			log.Warnf("Cannot clear violation labels of %s: %s", owner.Type, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfrog/kubexray/xray"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecoverLabelled(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	exception := func(image string) []Exception {
+		return []Exception{{ID: "CVE-2020-1234", Images: []string{image}, expiry: future}}
+	}
+	tests := []struct {
+		name       string
+		status     int
+		recognized bool
+		exceptions []Exception
+		wantKept   bool
+	}{
+		{"clean", http.StatusOK, false, nil, false},
+		{"still violating", http.StatusOK, true, nil, true},
+		{"excepted for the image", http.StatusOK, true, exception("web:*"), false},
+		{"excepted for another image", http.StatusOK, true, exception("api:*"), true},
+		{"xray unavailable", http.StatusInternalServerError, false, nil, true},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.WriteHeader(tt.status)
+			if !tt.recognized {
+				resp.Write([]byte(`{"sha256": "abc", "ids": []}`))
+			} else if strings.HasPrefix(req.URL.Path, "/api/v1/componentIdsByChecksum/") {
+				resp.Write([]byte(`{"sha256": "abc", "ids": [{"package_id": "docker://web", "version": "1"}]}`))
+			} else {
+				resp.Write([]byte(`{"total_count": 1, "data": [{"type": "security", "severity": "High", "issue_id": "XRAY-1", "cves": [{"cve": "CVE-2020-1234"}]}]}`))
+			}
+		}))
+		rset := &apps_v1.ReplicaSet{ObjectMeta: ownedMeta("web-5d4f", "rs-uid", "Deployment", "web", "dep-uid")}
+		pod := &core_v1.Pod{ObjectMeta: ownedMeta("web-5d4f-x7k2p", "pod-uid", "ReplicaSet", "web-5d4f", "rs-uid")}
+		client := fake.NewSimpleClientset(testDeployment("web", 3, nil), rset, pod)
+		owner := Owner{Type: Deployment, Name: "web", Namespace: "default", UID: "dep-uid"}
+		comps := []NotifyComponentPayload{{Name: "web:1", Checksum: "abc"}}
+		if err := labelOwner(client, pod, owner, Violation{Types: []string{"security"}, Severity: "High"}, comps); err != nil {
+			t.Fatalf("%s: labelOwner: %v", tt.name, err)
+		}
+		handler := &HandlerImpl{
+			url:        srv.URL,
+			xray:       xray.NewClient(srv.URL, "", "", xray.Options{}),
+			cache:      newScanCache(CacheOptions{}),
+			unscanned:  Policy{deployments: Ignore},
+			security:   Policy{deployments: Label},
+			exceptions: tt.exceptions,
+		}
+		recoverQuarantined(context.Background(), handler, client)
+		srv.Close()
+		dep := getDeployment(t, client, "web")
+		got, err := client.CoreV1().Pods("default").Get(context.TODO(), pod.Name, meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: get pod: %v", tt.name, err)
+		}
+		for _, meta := range []meta_v1.ObjectMeta{dep.ObjectMeta, got.ObjectMeta} {
+			_, labelled := meta.Labels[violationLabel]
+			_, severity := meta.Labels[severityLabel]
+			_, digests := meta.Annotations[violationDigestsAnnotation]
+			if labelled != tt.wantKept || severity != tt.wantKept || digests != tt.wantKept {
+				t.Errorf("%s: %s has labels %v and annotations %v, want kept %v", tt.name, meta.Name, meta.Labels, meta.Annotations, tt.wantKept)
+			}
+		}
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"strings"
 	"syscall"
 
-	log "github.com/Sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	core_v1 "k8s.io/client-go/informers/core/v1"
 	api_core_v1 "k8s.io/api/core/v1"
@@ -16,10 +16,8 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/client-go/rest"
-
-	// Import to initialize client auth plugins.
-	// Only GCP GKE auth is supported, Azure auth crashes with go-client v9.0.0
-	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	// GKE and other cloud providers authenticate with exec credential
+	// plugins, which client-go supports without importing auth plugins
 )
 
 // retrieve the k8s cluster client from within/outside of the cluster
@@ -86,6 +84,10 @@ func setLogLevel() {
 
 // main code path
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfig(os.Args[2:]))
+	}
+
 	setLogLevel()
 
 	client, config := getKubernetesClient()
@@ -117,13 +119,11 @@ func main() {
 				enqueuePod(obj, queue, true)
 			}
 		},
-		//Only called on re-sync
+		//Called on every status change; only re-check pods whose images changed
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			key, err := cache.MetaNamespaceKeyFunc(newObj)
 			log.Debugf("Update pod: %s", key)
-			if err == nil {
-				store := informer.GetStore()
-				store = store
+			if err == nil && podChanged(oldObj.(*api_core_v1.Pod), newObj.(*api_core_v1.Pod)) {
 				enqueuePod(newObj, queue, true)
 			}
 		},
@@ -172,16 +172,51 @@ func main() {
 	<-sigTerm
 }
 
-func enqueuePod(obj interface{}, queue workqueue.RateLimitingInterface, includeOnlyRunning bool) bool {
+func enqueuePod(obj interface{}, queue workqueue.RateLimitingInterface, includeOnlyActive bool) bool {
 	//We copy the object to the cache rather than store it by a string key and getting
 	//it back from the index by this key, since when we get it by key it is already deleted
 	//and does not contain the object in the index (from which we need to extract the containers)
 	pod := obj.(*api_core_v1.Pod)
-	//Filter pending pods
-	if includeOnlyRunning && pod.Status.Phase != api_core_v1.PodRunning {
+	//Filter finished pods; pending pods are checked too, so that images that cannot
+	//start (e.g. stuck pulling or crash looping) do not go unnoticed
+	if includeOnlyActive && (pod.Status.Phase == api_core_v1.PodSucceeded || pod.Status.Phase == api_core_v1.PodFailed) {
 		return false
 	}
 	copy := pod.DeepCopy()
 	queue.Add(copy)
 	return true
 }
+
+// get the images of a pod along with the digests reported for them, which
+// is what its checks depend on
+func podImageKey(pod *api_core_v1.Pod) string {
+	images := make([]string, 0)
+	for _, container := range pod.Spec.InitContainers {
+		images = append(images, container.Name+"="+container.Image)
+	}
+	for _, container := range pod.Spec.Containers {
+		images = append(images, container.Name+"="+container.Image)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		images = append(images, container.Name+"="+container.Image)
+	}
+	for _, statuses := range [][]api_core_v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, status := range statuses {
+			images = append(images, status.Name+"@"+status.ImageID)
+		}
+	}
+	return strings.Join(images, ",")
+}
+
+// check whether an update to a pod calls for checking it again, because its
+// images or their digests changed or it became active again; other status
+// updates, such as probes and restarts, would only repeat the same check
+func podChanged(oldPod, newPod *api_core_v1.Pod) bool {
+	finished := func(pod *api_core_v1.Pod) bool {
+		return pod.Status.Phase == api_core_v1.PodSucceeded || pod.Status.Phase == api_core_v1.PodFailed
+	}
+	if finished(oldPod) && !finished(newPod) {
+		return true
+	}
+	return podImageKey(oldPod) != podImageKey(newPod)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+func TestPodChanged(t *testing.T) {
+	pod := func(image, imageID string, phase core_v1.PodPhase, restarts int32) *core_v1.Pod {
+		return &core_v1.Pod{
+			Spec: core_v1.PodSpec{Containers: []core_v1.Container{{Name: "app", Image: image}}},
+			Status: core_v1.PodStatus{
+				Phase:             phase,
+				ContainerStatuses: []core_v1.ContainerStatus{{Name: "app", ImageID: imageID, RestartCount: restarts}},
+			},
+		}
+	}
+	running := pod("web:1", "web@sha256:abc", core_v1.PodRunning, 0)
+	tests := []struct {
+		name   string
+		newPod *core_v1.Pod
+		want   bool
+	}{
+		{"unchanged", pod("web:1", "web@sha256:abc", core_v1.PodRunning, 0), false},
+		{"restarted", pod("web:1", "web@sha256:abc", core_v1.PodRunning, 3), false},
+		{"image updated", pod("web:2", "web@sha256:abc", core_v1.PodRunning, 0), true},
+		{"digest pulled", pod("web:1", "web@sha256:def", core_v1.PodRunning, 0), true},
+		{"finished", pod("web:1", "web@sha256:abc", core_v1.PodSucceeded, 0), false},
+	}
+	for _, tt := range tests {
+		if got := podChanged(running, tt.newPod); got != tt.want {
+			t.Errorf("%s: podChanged = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// a finished pod becoming active again is checked again
+	failed := pod("web:1", "web@sha256:abc", core_v1.PodFailed, 0)
+	if !podChanged(failed, running) {
+		t.Error("podChanged = false for a failed pod running again")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// annotation overriding the action for every violation type
+	policyAnnotation = "kubexray.io/policy"
+	// annotations overriding the action for a single violation type
+	unscannedAnnotation = "kubexray.io/unscanned-action"
+	securityAnnotation  = "kubexray.io/security-action"
+	licenseAnnotation   = "kubexray.io/license-action"
+	// annotation holding the time after which the overrides no longer apply
+	expiryAnnotation = "kubexray.io/policy-expires"
+)
+
+// the config.yaml key of the action for each resource type
+var policyKeys = map[ResourceType]string{
+	StatefulSet:           "statefulSets",
+	Deployment:            "deployments",
+	DaemonSet:             "daemonSets",
+	Job:                   "jobs",
+	CronJob:               "cronJobs",
+	Pod:                   "pods",
+	ReplicaSet:            "replicaSets",
+	ReplicationController: "replicationControllers",
+}
+
+// get a copy of a policy taking the given action against every resource type
+func (x Policy) withAction(act Action) Policy {
+	x.deployments = act
+	x.statefulSets = act
+	x.daemonSets = act
+	x.jobs = act
+	x.cronJobs = act
+	x.pods = act
+	x.replicaSets = act
+	x.replicationControllers = act
+	x.overridden = true
+	return x
+}
+
+// check whether the override annotations of an object have expired
+func overridesExpired(name string, annotations map[string]string) bool {
+	val, ok := annotations[expiryAnnotation]
+	if !ok {
+		return false
+	}
+	expiry, err := parseExpiry(val)
+	if err != nil {
+		log.Warnf("Ignoring overrides of %s: cannot read expiry '%s'", name, val)
+		return true
+	}
+	if time.Now().After(expiry) {
+		log.Debugf("Ignoring overrides of %s: expired at %s", name, val)
+		return true
+	}
+	return false
+}
+
+// parse an expiry given either as an RFC 3339 timestamp or a plain date
+func parseExpiry(val string) (time.Time, error) {
+	expiry, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		expiry, err = time.Parse("2006-01-02", val)
+	}
+	return expiry, err
+}
+
+// apply the override annotations of an object to the given policies
+func applyAnnotations(pols Policies, typ ResourceType, name string, annotations map[string]string) Policies {
+	if len(annotations) == 0 || overridesExpired(name, annotations) {
+		return pols
+	}
+	key := policyKeys[typ]
+	read := func(annotation string) (Action, bool) {
+		val, ok := annotations[annotation]
+		if !ok {
+			return Ignore, false
+		}
+		act, err := readAction(map[string]interface{}{key: val}, key, true)
+		if err != nil {
+			log.Warnf("Ignoring annotation %s of %s: %s", annotation, name, err)
+			return Ignore, false
+		}
+		return act, true
+	}
+	if act, ok := read(policyAnnotation); ok {
+		pols.unscanned = pols.unscanned.withAction(act)
+		pols.security = pols.security.withAction(act)
+		pols.license = pols.license.withAction(act)
+	}
+	if act, ok := read(unscannedAnnotation); ok {
+		pols.unscanned = pols.unscanned.withAction(act)
+	}
+	if act, ok := read(securityAnnotation); ok {
+		pols.security = pols.security.withAction(act)
+	}
+	if act, ok := read(licenseAnnotation); ok {
+		pols.license = pols.license.withAction(act)
+	}
+	return pols
+}
+
+// get the policies for a pod after applying the override annotations of its
+// owner and then of the pod itself (which may be nil), so that the most
+// specific annotation wins
+func applyOverrides(client kubernetes.Interface, pols Policies, pod *core_v1.Pod, owner Owner) Policies {
+	if owner.Type != Unrecognized && owner.Type != Pod {
+		obj, _, err := getObject(client, owner.Namespace, resourceKinds[owner.Type].Kind, owner.Name)
+		if err != nil {
+			log.Debugf("Cannot read annotations of %s %s: %v", owner.Type, owner.Name, err)
+		} else {
+			pols = applyAnnotations(pols, owner.Type, owner.Name, obj.GetAnnotations())
+		}
+	}
+	if pod != nil {
+		pols = applyAnnotations(pols, owner.Type, pod.Name, pod.Annotations)
+	}
+	return pols
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2030-01-02", time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{"2030-01-02T03:04:05Z", time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), false},
+		{"2030-01-02T03:04:05+02:00", time.Date(2030, 1, 2, 1, 4, 5, 0, time.UTC), false},
+		{"2030-01-02 03:04", time.Time{}, true},
+		{"tomorrow", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.val)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseExpiry(%q) error = %v, want error %v", tt.val, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !got.Equal(tt.want) {
+			t.Errorf("parseExpiry(%q) = %s, want %s", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestOverridesExpired(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		want        bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{expiryAnnotation: time.Now().Add(time.Hour).Format(time.RFC3339)}, false},
+		{map[string]string{expiryAnnotation: time.Now().Add(-time.Hour).Format(time.RFC3339)}, true},
+		{map[string]string{expiryAnnotation: "2000-01-01"}, true},
+		// an unreadable expiry is treated as expired, rather than forever
+		{map[string]string{expiryAnnotation: "soon"}, true},
+	}
+	for _, tt := range tests {
+		if got := overridesExpired("test", tt.annotations); got != tt.want {
+			t.Errorf("overridesExpired(%v) = %v, want %v", tt.annotations, got, tt.want)
+		}
+	}
+}
+
+func TestApplyAnnotations(t *testing.T) {
+	base := Policies{
+		unscanned: Policy{deployments: Label},
+		security:  Policy{deployments: Delete},
+		license:   Policy{deployments: Isolate},
+	}
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        [3]Action
+	}{
+		{"none", nil, [3]Action{Label, Delete, Isolate}},
+		{"all", map[string]string{policyAnnotation: "ignore"}, [3]Action{Ignore, Ignore, Ignore}},
+		{"single", map[string]string{securityAnnotation: "label"}, [3]Action{Label, Label, Isolate}},
+		{"single over all", map[string]string{policyAnnotation: "ignore", licenseAnnotation: "delete"}, [3]Action{Ignore, Ignore, Delete}},
+		{"unexpired", map[string]string{policyAnnotation: "ignore", expiryAnnotation: future}, [3]Action{Ignore, Ignore, Ignore}},
+		{"expired", map[string]string{policyAnnotation: "ignore", expiryAnnotation: "2000-01-01"}, [3]Action{Label, Delete, Isolate}},
+		{"unknown action", map[string]string{unscannedAnnotation: "explode"}, [3]Action{Label, Delete, Isolate}},
+	}
+	for _, tt := range tests {
+		pols := applyAnnotations(base, Deployment, "test", tt.annotations)
+		got := [3]Action{pols.unscanned.deployments, pols.security.deployments, pols.license.deployments}
+		if got != tt.want {
+			t.Errorf("%s: actions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	base := Policies{
+		unscanned: Policy{deployments: Label},
+		security:  Policy{deployments: Delete},
+		license:   Policy{deployments: Isolate},
+	}
+	client := fake.NewSimpleClientset(testDeployment("web", 1, map[string]string{policyAnnotation: "ignore"}))
+	owner := Owner{Type: Deployment, Name: "web", Namespace: "default", UID: "dep-uid"}
+	pod := &core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{
+		Name:        "web-5d4f-x7k2p",
+		Namespace:   "default",
+		Annotations: map[string]string{securityAnnotation: "scaledown"},
+	}}
+	// the pod's annotation is more specific than its owner's
+	pols := applyOverrides(client, base, pod, owner)
+	got := [3]Action{pols.unscanned.deployments, pols.security.deployments, pols.license.deployments}
+	if want := [3]Action{Ignore, Scaledown, Ignore}; got != want {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+	// a workload that cannot be read keeps the pod's overrides only
+	missing := Owner{Type: Deployment, Name: "api", Namespace: "default"}
+	pols = applyOverrides(client, base, pod, missing)
+	got = [3]Action{pols.unscanned.deployments, pols.security.deployments, pols.license.deployments}
+	if want := [3]Action{Label, Scaledown, Isolate}; got != want {
+		t.Errorf("actions without owner = %v, want %v", got, want)
+	}
+}
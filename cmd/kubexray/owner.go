@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Owner is a typed reference to the workload that ultimately controls a pod.
+type Owner struct {
+	Type      ResourceType
+	Name      string
+	Namespace string
+	UID       types.UID
+}
+
+// String returns the resource type of the owner.
+func (r ResourceType) String() string {
+	switch r {
+	case StatefulSet:
+		return "stateful set"
+	case Deployment:
+		return "deployment"
+	case DaemonSet:
+		return "daemon set"
+	case Job:
+		return "job"
+	case CronJob:
+		return "cron job"
+	case Pod:
+		return "pod"
+	case ReplicaSet:
+		return "replica set"
+	case ReplicationController:
+		return "replication controller"
+	}
+	return "unrecognized resource"
+}
+
+// the Kubernetes api version and kind of each recognized resource type
+var resourceKinds = map[ResourceType]meta_v1.TypeMeta{
+	StatefulSet:           {APIVersion: "apps/v1", Kind: "StatefulSet"},
+	Deployment:            {APIVersion: "apps/v1", Kind: "Deployment"},
+	DaemonSet:             {APIVersion: "apps/v1", Kind: "DaemonSet"},
+	Job:                   {APIVersion: "batch/v1", Kind: "Job"},
+	CronJob:               {APIVersion: "batch/v1", Kind: "CronJob"},
+	Pod:                   {APIVersion: "v1", Kind: "Pod"},
+	ReplicaSet:            {APIVersion: "apps/v1", Kind: "ReplicaSet"},
+	ReplicationController: {APIVersion: "v1", Kind: "ReplicationController"},
+}
+
+// get the resource type of a Kubernetes kind
+func kindType(kind string) ResourceType {
+	for typ, meta := range resourceKinds {
+		if meta.Kind == kind {
+			return typ
+		}
+	}
+	return Unrecognized
+}
+
+// get an owner reference pointing at the given owner, so that dependent
+// objects are garbage collected along with it
+func ownerReference(owner Owner) meta_v1.OwnerReference {
+	meta := resourceKinds[owner.Type]
+	return meta_v1.OwnerReference{APIVersion: meta.APIVersion, Kind: meta.Kind, Name: owner.Name, UID: owner.UID}
+}
+
+// get the object referenced by a controller owner reference, along with the
+// resource type it represents
+func getOwnerObject(client kubernetes.Interface, namespace string, ref *meta_v1.OwnerReference) (meta_v1.Object, ResourceType, error) {
+	obj, typ, err := getObject(client, namespace, ref.Kind, ref.Name)
+	if err != nil {
+		return nil, Unrecognized, err
+	}
+	// the name may have been reused by a new object since the reference was set
+	if obj.GetUID() != ref.UID {
+		return nil, Unrecognized, errors.New("owner " + ref.Kind + " " + ref.Name + " has been replaced")
+	}
+	return obj, typ, nil
+}
+
+// get an object of a supported kind by name, along with the resource type it
+// represents
+func getObject(client kubernetes.Interface, namespace, kind, name string) (meta_v1.Object, ResourceType, error) {
+	var obj meta_v1.Object
+	var typ ResourceType
+	var err error
+	switch kind {
+	case "StatefulSet":
+		obj, err = client.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+		typ = StatefulSet
+	case "Deployment":
+		obj, err = client.AppsV1().Deployments(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+		typ = Deployment
+	case "DaemonSet":
+		obj, err = client.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+		typ = DaemonSet
+	case "Job":
+		obj, err = client.BatchV1().Jobs(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+		typ = Job
+	case "CronJob":
+		obj, err = client.BatchV1().CronJobs(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+		typ = CronJob
+	case "ReplicaSet":
+		obj, err = client.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+		typ = ReplicaSet
+	case "ReplicationController":
+		obj, err = client.CoreV1().ReplicationControllers(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+		typ = ReplicationController
+	case "Pod":
+		obj, err = client.CoreV1().Pods(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+		typ = Pod
+	default:
+		return nil, Unrecognized, errors.New("unsupported kind " + kind)
+	}
+	if err != nil {
+		return nil, Unrecognized, err
+	}
+	return obj, typ, nil
+}
+
+// get the owning workload of a given pod by walking its controller owner
+// references (Pod -> ReplicaSet -> Deployment, Pod -> Job -> CronJob, ...);
+// the owner is unrecognized unless the walk reaches an object without a
+// controller, so that e.g. the replica sets of an unsupported controller are
+// not acted upon directly
+func checkResource(client kubernetes.Interface, pod *core_v1.Pod) Owner {
+	owner := Owner{Type: Unrecognized, Namespace: pod.Namespace}
+	if meta_v1.GetControllerOf(pod) == nil {
+		// a pod created directly is its own owner
+		owner = Owner{Type: Pod, Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID}
+	}
+	var obj meta_v1.Object = pod
+	for {
+		ref := meta_v1.GetControllerOf(obj)
+		if ref == nil {
+			break
+		}
+		parent, typ, err := getOwnerObject(client, pod.Namespace, ref)
+		if err != nil {
+			log.Debugf("Cannot resolve owner %s %s of %s: %v", ref.Kind, ref.Name, obj.GetName(), err)
+			owner = Owner{Type: Unrecognized, Namespace: pod.Namespace}
+			break
+		}
+		owner = Owner{Type: typ, Name: parent.GetName(), Namespace: pod.Namespace, UID: parent.GetUID()}
+		obj = parent
+	}
+	if owner.Type == Unrecognized {
+		log.Debugf("Resource for pod %s is not a recognized resource type", pod.Name)
+	} else {
+		log.Debugf("Resource for pod %s is %s %s", pod.Name, owner.Type, owner.Name)
+	}
+	return owner
+}
+
+// remove a pod by deleting its owner, scaling it to zero replicas,
+// suspending it, evicting it, isolating it from the network, or just
+// labelling it
+func removePod(client kubernetes.Interface, pod *core_v1.Pod, owner Owner, action Action, violation Violation, comps []NotifyComponentPayload) {
+	if action == Delete {
+		log.Infof("Deleting %s: %s", owner.Type, owner.Name)
+		err := deleteOwner(client, owner)
+		if err != nil {
+			log.Warnf("Cannot delete %s: %s", owner.Type, err)
+		}
+		return
+	}
+	if action == Label {
+		log.Infof("Labelling %s as violating: %s", owner.Type, owner.Name)
+		err := labelOwner(client, pod, owner, violation, comps)
+		if err != nil {
+			log.Warnf("Cannot label %s: %s", owner.Type, err)
+		}
+		return
+	}
+	if action == Isolate {
+		log.Infof("Isolating %s from the network: %s", owner.Type, owner.Name)
+		err := isolateOwner(client, pod, owner, comps)
+		if err != nil {
+			log.Warnf("Cannot isolate %s: %s", owner.Type, err)
+		}
+		return
+	}
+	if action == Evict && owner.Type == Pod {
+		log.Infof("Evicting pod: %s", owner.Name)
+		err := evictPod(client, owner)
+		if err != nil {
+			log.Warnf("Cannot evict pod: %s", err)
+		}
+		return
+	}
+	if action != Scaledown && action != Suspend {
+		log.Warnf("Unable to handle case: action = %v, type = %v", action, owner.Type)
+		return
+	}
+	var err error
+	switch owner.Type {
+	case StatefulSet:
+		log.Infof("Scaling stateful set to zero pods: %s", owner.Name)
+		err = scaleStatefulSet(client, owner, comps)
+	case Deployment:
+		log.Infof("Scaling deployment to zero pods: %s", owner.Name)
+		err = scaleDeployment(client, owner, comps)
+	case DaemonSet:
+		// daemon sets cannot be scaled, so unschedule them from every node instead
+		log.Infof("Unscheduling daemon set from all nodes: %s", owner.Name)
+		err = quarantineDaemonSet(client, owner, comps)
+	case Job:
+		log.Infof("Scaling job to zero parallel pods: %s", owner.Name)
+		err = scaleJob(client, owner)
+	case CronJob:
+		log.Infof("Suspending cron job: %s", owner.Name)
+		err = suspendCronJob(client, owner)
+	case ReplicaSet:
+		log.Infof("Scaling replica set to zero pods: %s", owner.Name)
+		err = scaleReplicaSet(client, owner, comps)
+	case ReplicationController:
+		log.Infof("Scaling replication controller to zero pods: %s", owner.Name)
+		err = scaleReplicationController(client, owner, comps)
+	default:
+		log.Warnf("Unable to handle case: action = %v, type = %v", action, owner.Type)
+		return
+	}
+	if err != nil {
+		log.Warnf("Cannot update %s: %s", owner.Type, err)
+	}
+}
+
+// delete the owner of a pod, along with all of its dependents
+func deleteOwner(client kubernetes.Interface, owner Owner) error {
+	// only ever act on the exact object the pod was resolved to
+	opts := meta_v1.DeleteOptions{Preconditions: meta_v1.NewUIDPreconditions(string(owner.UID))}
+	propagation := meta_v1.DeletePropagationBackground
+	opts.PropagationPolicy = &propagation
+	switch owner.Type {
+	case StatefulSet:
+		return client.AppsV1().StatefulSets(owner.Namespace).Delete(context.TODO(), owner.Name, opts)
+	case Deployment:
+		return client.AppsV1().Deployments(owner.Namespace).Delete(context.TODO(), owner.Name, opts)
+	case DaemonSet:
+		return client.AppsV1().DaemonSets(owner.Namespace).Delete(context.TODO(), owner.Name, opts)
+	case Job:
+		return client.BatchV1().Jobs(owner.Namespace).Delete(context.TODO(), owner.Name, opts)
+	case CronJob:
+		return client.BatchV1().CronJobs(owner.Namespace).Delete(context.TODO(), owner.Name, opts)
+	case Pod:
+		return client.CoreV1().Pods(owner.Namespace).Delete(context.TODO(), owner.Name, opts)
+	case ReplicaSet:
+		return client.AppsV1().ReplicaSets(owner.Namespace).Delete(context.TODO(), owner.Name, opts)
+	case ReplicationController:
+		return client.CoreV1().ReplicationControllers(owner.Namespace).Delete(context.TODO(), owner.Name, opts)
+	}
+	return errors.New("cannot delete " + owner.Type.String())
+}
+
+// scale a stateful set to zero replicas
+func scaleStatefulSet(client kubernetes.Interface, owner Owner, comps []NotifyComponentPayload) error {
+	sets := client.AppsV1().StatefulSets(owner.Namespace)
+	set, err := sets.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if set.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	markQuarantined(&set.ObjectMeta, set.Spec.Replicas, comps)
+	*set.Spec.Replicas = 0
+	_, err = sets.Update(context.TODO(), set, meta_v1.UpdateOptions{})
+	return err
+}
+
+// scale a deployment to zero replicas
+func scaleDeployment(client kubernetes.Interface, owner Owner, comps []NotifyComponentPayload) error {
+	deps := client.AppsV1().Deployments(owner.Namespace)
+	dep, err := deps.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if dep.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	markQuarantined(&dep.ObjectMeta, dep.Spec.Replicas, comps)
+	*dep.Spec.Replicas = 0
+	_, err = deps.Update(context.TODO(), dep, meta_v1.UpdateOptions{})
+	return err
+}
+
+// scale a replica set that is not managed by a deployment to zero replicas
+func scaleReplicaSet(client kubernetes.Interface, owner Owner, comps []NotifyComponentPayload) error {
+	rsets := client.AppsV1().ReplicaSets(owner.Namespace)
+	rset, err := rsets.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if rset.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	markQuarantined(&rset.ObjectMeta, rset.Spec.Replicas, comps)
+	*rset.Spec.Replicas = 0
+	_, err = rsets.Update(context.TODO(), rset, meta_v1.UpdateOptions{})
+	return err
+}
+
+// scale a replication controller to zero replicas
+func scaleReplicationController(client kubernetes.Interface, owner Owner, comps []NotifyComponentPayload) error {
+	rcs := client.CoreV1().ReplicationControllers(owner.Namespace)
+	rc, err := rcs.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if rc.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	markQuarantined(&rc.ObjectMeta, rc.Spec.Replicas, comps)
+	*rc.Spec.Replicas = 0
+	_, err = rcs.Update(context.TODO(), rc, meta_v1.UpdateOptions{})
+	return err
+}
+
+// evict a bare pod, honouring any pod disruption budgets that cover it
+func evictPod(client kubernetes.Interface, owner Owner) error {
+	eviction := &policy_v1.Eviction{
+		ObjectMeta:    meta_v1.ObjectMeta{Name: owner.Name, Namespace: owner.Namespace},
+		DeleteOptions: &meta_v1.DeleteOptions{Preconditions: meta_v1.NewUIDPreconditions(string(owner.UID))},
+	}
+	return client.CoreV1().Pods(owner.Namespace).EvictV1(context.TODO(), eviction)
+}
+
+// stop a job from running any more pods by setting its parallelism to zero
+func scaleJob(client kubernetes.Interface, owner Owner) error {
+	jobs := client.BatchV1().Jobs(owner.Namespace)
+	job, err := jobs.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if job.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	zero := int32(0)
+	job.Spec.Parallelism = &zero
+	_, err = jobs.Update(context.TODO(), job, meta_v1.UpdateOptions{})
+	return err
+}
+
+// stop a cron job from scheduling any more jobs
+func suspendCronJob(client kubernetes.Interface, owner Owner) error {
+	cjobs := client.BatchV1().CronJobs(owner.Namespace)
+	cjob, err := cjobs.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if cjob.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	suspend := true
+	cjob.Spec.Suspend = &suspend
+	_, err = cjobs.Update(context.TODO(), cjob, meta_v1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// object metadata in the default namespace, controlled by the given owner
+func ownedMeta(name string, uid types.UID, kind, owner string, ownerUID types.UID) meta_v1.ObjectMeta {
+	meta := meta_v1.ObjectMeta{Name: name, Namespace: "default", UID: uid}
+	if kind != "" {
+		controller := true
+		meta.OwnerReferences = []meta_v1.OwnerReference{{Kind: kind, Name: owner, UID: ownerUID, Controller: &controller}}
+	}
+	return meta
+}
+
+func TestCheckResource(t *testing.T) {
+	dep := &apps_v1.Deployment{ObjectMeta: ownedMeta("web", "dep-uid", "", "", "")}
+	rset := &apps_v1.ReplicaSet{ObjectMeta: ownedMeta("web-5d4f", "rs-uid", "Deployment", "web", "dep-uid")}
+	bareRset := &apps_v1.ReplicaSet{ObjectMeta: ownedMeta("cache-8c7d", "bare-rs-uid", "", "", "")}
+	rc := &core_v1.ReplicationController{ObjectMeta: ownedMeta("legacy", "rc-uid", "", "", "")}
+	set := &apps_v1.StatefulSet{ObjectMeta: ownedMeta("db", "set-uid", "", "", "")}
+	dset := &apps_v1.DaemonSet{ObjectMeta: ownedMeta("agent", "ds-uid", "", "", "")}
+	cjob := &batch_v1.CronJob{ObjectMeta: ownedMeta("backup", "cj-uid", "", "", "")}
+	cronjob := &batch_v1.Job{ObjectMeta: ownedMeta("backup-27950", "cronjob-uid", "CronJob", "backup", "cj-uid")}
+	job := &batch_v1.Job{ObjectMeta: ownedMeta("migrate", "job-uid", "", "", "")}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		pod     meta_v1.ObjectMeta
+		want    Owner
+	}{
+		{
+			"deployment",
+			[]runtime.Object{dep, rset},
+			ownedMeta("web-5d4f-x7k2p", "pod-uid", "ReplicaSet", "web-5d4f", "rs-uid"),
+			Owner{Type: Deployment, Name: "web", Namespace: "default", UID: "dep-uid"},
+		},
+		{
+			"stateful set",
+			[]runtime.Object{set},
+			ownedMeta("db-0", "pod-uid", "StatefulSet", "db", "set-uid"),
+			Owner{Type: StatefulSet, Name: "db", Namespace: "default", UID: "set-uid"},
+		},
+		{
+			"daemon set",
+			[]runtime.Object{dset},
+			ownedMeta("agent-q8v4n", "pod-uid", "DaemonSet", "agent", "ds-uid"),
+			Owner{Type: DaemonSet, Name: "agent", Namespace: "default", UID: "ds-uid"},
+		},
+		{
+			"job",
+			[]runtime.Object{job},
+			ownedMeta("migrate-h2b9c", "pod-uid", "Job", "migrate", "job-uid"),
+			Owner{Type: Job, Name: "migrate", Namespace: "default", UID: "job-uid"},
+		},
+		{
+			"cron job",
+			[]runtime.Object{cjob, cronjob},
+			ownedMeta("backup-27950-l9w8d", "pod-uid", "Job", "backup-27950", "cronjob-uid"),
+			Owner{Type: CronJob, Name: "backup", Namespace: "default", UID: "cj-uid"},
+		},
+		{
+			// the stateful set was deleted and recreated under the same name
+			"replaced owner",
+			[]runtime.Object{set},
+			ownedMeta("db-0", "pod-uid", "StatefulSet", "db", "old-set-uid"),
+			Owner{Type: Unrecognized, Namespace: "default"},
+		},
+		{
+			"replaced intermediate owner",
+			[]runtime.Object{dep, rset},
+			ownedMeta("web-5d4f-x7k2p", "pod-uid", "ReplicaSet", "web-5d4f", "old-rs-uid"),
+			Owner{Type: Unrecognized, Namespace: "default"},
+		},
+		{
+			"missing intermediate owner",
+			[]runtime.Object{dep},
+			ownedMeta("web-5d4f-x7k2p", "pod-uid", "ReplicaSet", "web-5d4f", "rs-uid"),
+			Owner{Type: Unrecognized, Namespace: "default"},
+		},
+		{
+			// the replica set belongs to a deployment that is gone
+			"missing top-level owner",
+			[]runtime.Object{rset},
+			ownedMeta("web-5d4f-x7k2p", "pod-uid", "ReplicaSet", "web-5d4f", "rs-uid"),
+			Owner{Type: Unrecognized, Namespace: "default"},
+		},
+		{
+			"missing cron job",
+			[]runtime.Object{cronjob},
+			ownedMeta("backup-27950-l9w8d", "pod-uid", "Job", "backup-27950", "cronjob-uid"),
+			Owner{Type: Unrecognized, Namespace: "default"},
+		},
+		{
+			"bare pod",
+			nil,
+			ownedMeta("debug", "pod-uid", "", "", ""),
+			Owner{Type: Pod, Name: "debug", Namespace: "default", UID: "pod-uid"},
+		},
+		{
+			"replica set without a deployment",
+			[]runtime.Object{bareRset},
+			ownedMeta("cache-8c7d-2xq9z", "pod-uid", "ReplicaSet", "cache-8c7d", "bare-rs-uid"),
+			Owner{Type: ReplicaSet, Name: "cache-8c7d", Namespace: "default", UID: "bare-rs-uid"},
+		},
+		{
+			"replication controller",
+			[]runtime.Object{rc},
+			ownedMeta("legacy-m4t6v", "pod-uid", "ReplicationController", "legacy", "rc-uid"),
+			Owner{Type: ReplicationController, Name: "legacy", Namespace: "default", UID: "rc-uid"},
+		},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(tt.objects...)
+		got := checkResource(client, &core_v1.Pod{ObjectMeta: tt.pod})
+		if got != tt.want {
+			t.Errorf("%s: checkResource = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	log "github.com/sirupsen/logrus"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Policies is the set of policies that apply to a namespace.
+type Policies struct {
+	unscanned Policy
+	security  Policy
+	license   Policy
+}
+
+// NamespacePolicy encodes an entry of the namespaces section of the
+// config.yaml file, overriding the default policies for the namespaces
+// matching a name or glob pattern and/or a label selector.
+type NamespacePolicy struct {
+	Namespace string  `yaml:"namespace"`
+	Selector  string  `yaml:"selector"`
+	Unscanned *Policy `yaml:"unscanned"`
+	Security  *Policy `yaml:"security"`
+	License   *Policy `yaml:"license"`
+	selector  labels.Selector
+}
+
+// validate the namespace pattern and parse the label selector of an override
+func (n *NamespacePolicy) init() error {
+	if n.Namespace == "" && n.Selector == "" {
+		return errors.New("namespace policy requires a namespace or a selector")
+	}
+	if _, err := path.Match(n.Namespace, ""); err != nil {
+		return errors.New("Cannot read namespace pattern '" + n.Namespace + "': " + err.Error())
+	}
+	if n.Selector != "" {
+		sel, err := labels.Parse(n.Selector)
+		if err != nil {
+			return errors.New("Cannot read selector '" + n.Selector + "': " + err.Error())
+		}
+		n.selector = sel
+	}
+	return nil
+}
+
+// check if an override applies to the namespace with the given name and labels
+func (n *NamespacePolicy) matches(namespace string, nslabels labels.Set) bool {
+	if n.Namespace != "" {
+		if ok, _ := path.Match(n.Namespace, namespace); !ok {
+			return false
+		}
+	}
+	return n.selector == nil || n.selector.Matches(nslabels)
+}
+
+// get the labels of the given namespace
+func namespaceLabels(client kubernetes.Interface, namespace string) labels.Set {
+	ns, err := client.CoreV1().Namespaces().Get(context.TODO(), namespace, meta_v1.GetOptions{})
+	if err != nil {
+		log.Warnf("Cannot read labels of namespace %s: %s", namespace, err)
+		return labels.Set{}
+	}
+	if ns.Labels == nil {
+		return labels.Set{}
+	}
+	return labels.Set(ns.Labels)
+}
+
+// get the policies that apply to the given namespace, using the first
+// matching override in the namespaces section and falling back to the
+// default policies for anything it does not override
+func (t *HandlerImpl) policiesFor(client kubernetes.Interface, namespace string) Policies {
+	pols := Policies{t.unscanned, t.security, t.license}
+	var nslabels labels.Set
+	for i := range t.namespaces {
+		override := &t.namespaces[i]
+		if override.selector != nil && nslabels == nil {
+			nslabels = namespaceLabels(client, namespace)
+		}
+		if !override.matches(namespace, nslabels) {
+			continue
+		}
+		if override.Unscanned != nil {
+			pols.unscanned = *override.Unscanned
+			pols.unscanned.overridden = true
+		}
+		if override.Security != nil {
+			pols.security = *override.Security
+			pols.security.overridden = true
+		}
+		if override.License != nil {
+			pols.license = *override.License
+			pols.license.overridden = true
+		}
+		break
+	}
+	return pols
+}
+
+// get the most severe action the policies require for a resource of the
+// given type with the given scan results
+func (p Policies) action(typ ResourceType, rec, seciss, liciss bool) Action {
+	action := Ignore
+	check := func(pol Policy) {
+		act := pol.action(typ)
+		if act.severity() > action.severity() {
+			action = act
+		}
+	}
+	if !rec {
+		check(p.unscanned)
+	}
+	if seciss {
+		check(p.security)
+	}
+	if liciss {
+		check(p.license)
+	}
+	return action
+}
+
+// get the most severe action the overridden policies require for a resource
+// of the given type with the given scan results, and whether any violation
+// is left to the policy expression
+func (p Policies) overrideAction(typ ResourceType, rec, seciss, liciss bool) (Action, bool) {
+	action := Ignore
+	rest := rec && !seciss && !liciss
+	check := func(pol Policy) {
+		if !pol.overridden {
+			rest = true
+			return
+		}
+		act := pol.action(typ)
+		if act.severity() > action.severity() {
+			action = act
+		}
+	}
+	if !rec {
+		check(p.unscanned)
+	}
+	if seciss {
+		check(p.security)
+	}
+	if liciss {
+		check(p.license)
+	}
+	return action, rest
+}
+
+// drop the findings about an image that the policies do not act upon for
+// the role of its container, returning nil if there is nothing left to report
+func (p Policies) filterRole(res ScanResult) *ScanResult {
+	if !res.Recognized {
+		if !p.unscanned.checks(res.Role) {
+			return nil
+		}
+		return &res
+	}
+	issues := make([]Issue, 0)
+	for _, iss := range res.Issues {
+		pol := p.security
+		if iss.Type == "license" {
+			pol = p.license
+		}
+		if pol.checks(res.Role) {
+			issues = append(issues, iss)
+		}
+	}
+	res.Issues = issues
+	return &res
+}
+
+// get the minimum severity of issues of the given type that the policies act upon
+func (p Policies) minSeverity(isstype string) Severity {
+	if isstype == "security" {
+		return p.security.threshold()
+	}
+	return p.license.threshold()
+}
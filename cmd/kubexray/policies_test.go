@@ -0,0 +1,200 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPoliciesAction(t *testing.T) {
+	pols := Policies{
+		unscanned: Policy{deployments: Label, statefulSets: Ignore, pods: Evict},
+		security:  Policy{deployments: Delete, statefulSets: Scaledown, pods: Evict},
+		license:   Policy{deployments: Isolate, statefulSets: Ignore},
+	}
+	tests := []struct {
+		typ                 ResourceType
+		rec, seciss, liciss bool
+		want                Action
+	}{
+		{Deployment, true, false, false, Ignore},
+		{Deployment, false, false, false, Label},
+		{Deployment, true, true, false, Delete},
+		{Deployment, true, false, true, Isolate},
+		{Deployment, true, true, true, Delete},
+		{Deployment, false, false, true, Isolate},
+		{StatefulSet, true, true, true, Scaledown},
+		{StatefulSet, false, false, true, Ignore},
+		{Pod, false, true, false, Evict},
+		{DaemonSet, false, true, true, Ignore},
+		{Unrecognized, false, true, true, Ignore},
+	}
+	for _, tt := range tests {
+		got := pols.action(tt.typ, tt.rec, tt.seciss, tt.liciss)
+		if got != tt.want {
+			t.Errorf("action(%s, %v, %v, %v) = %s, want %s", tt.typ, tt.rec, tt.seciss, tt.liciss, got, tt.want)
+		}
+	}
+}
+
+func TestPoliciesOverrideAction(t *testing.T) {
+	pols := Policies{
+		unscanned: Policy{deployments: Label},
+		security:  Policy{deployments: Ignore, overridden: true},
+		license:   Policy{deployments: Isolate, overridden: true},
+	}
+	tests := []struct {
+		rec, seciss, liciss bool
+		want                Action
+		wantRest            bool
+	}{
+		{true, false, false, Ignore, true},
+		{true, true, false, Ignore, false},
+		{true, true, true, Isolate, false},
+		{false, true, false, Ignore, true},
+		{false, false, true, Isolate, true},
+	}
+	for _, tt := range tests {
+		got, rest := pols.overrideAction(Deployment, tt.rec, tt.seciss, tt.liciss)
+		if got != tt.want || rest != tt.wantRest {
+			t.Errorf("overrideAction(%v, %v, %v) = %s, %v, want %s, %v", tt.rec, tt.seciss, tt.liciss, got, rest, tt.want, tt.wantRest)
+		}
+	}
+}
+
+func TestPoliciesFor(t *testing.T) {
+	handler := &HandlerImpl{
+		unscanned: Policy{deployments: Ignore},
+		security:  Policy{deployments: Scaledown},
+		license:   Policy{deployments: Ignore},
+		namespaces: []NamespacePolicy{
+			{Namespace: "prod-*", Security: &Policy{deployments: Delete}},
+			{Selector: "env=staging", License: &Policy{deployments: Label}},
+			{Namespace: "prod-legacy", Security: &Policy{deployments: Ignore}},
+		},
+	}
+	for i := range handler.namespaces {
+		if err := handler.namespaces[i].init(); err != nil {
+			t.Fatalf("init: %v", err)
+		}
+	}
+	client := fake.NewSimpleClientset(
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "qa", Labels: map[string]string{"env": "staging"}}},
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "default"}},
+	)
+	tests := []struct {
+		namespace      string
+		security       Action
+		license        Action
+		wantOverridden bool
+	}{
+		{"default", Scaledown, Ignore, false},
+		{"prod-web", Delete, Ignore, true},
+		// the first matching entry wins
+		{"prod-legacy", Delete, Ignore, true},
+		{"qa", Scaledown, Label, true},
+		// a namespace that cannot be read matches no selector
+		{"missing", Scaledown, Ignore, false},
+	}
+	for _, tt := range tests {
+		pols := handler.policiesFor(client, tt.namespace)
+		if got := pols.security.action(Deployment); got != tt.security {
+			t.Errorf("%s: security action = %s, want %s", tt.namespace, got, tt.security)
+		}
+		if got := pols.license.action(Deployment); got != tt.license {
+			t.Errorf("%s: license action = %s, want %s", tt.namespace, got, tt.license)
+		}
+		overridden := pols.security.overridden || pols.license.overridden
+		if overridden != tt.wantOverridden {
+			t.Errorf("%s: overridden = %v, want %v", tt.namespace, overridden, tt.wantOverridden)
+		}
+	}
+}
+
+func TestNamespacePolicyInit(t *testing.T) {
+	tests := []struct {
+		pol     NamespacePolicy
+		wantErr bool
+	}{
+		{NamespacePolicy{Namespace: "prod-*"}, false},
+		{NamespacePolicy{Selector: "env in (prod, staging)"}, false},
+		{NamespacePolicy{}, true},
+		{NamespacePolicy{Namespace: "prod-["}, true},
+		{NamespacePolicy{Selector: "env in prod"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.pol.init(); (err != nil) != tt.wantErr {
+			t.Errorf("init(%+v) error = %v, want error %v", tt.pol, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFilterRole(t *testing.T) {
+	pols := Policies{
+		unscanned: Policy{ignoreInit: true},
+		security:  Policy{ignoreEphemeral: true},
+		license:   Policy{ignoreInit: true},
+	}
+	issues := []Issue{{Type: "security", ID: "CVE-2020-1234"}, {Type: "license", ID: "GPL-3.0"}}
+	tests := []struct {
+		res        ScanResult
+		wantNil    bool
+		wantIssues int
+	}{
+		{ScanResult{Role: initContainerRole}, true, 0},
+		{ScanResult{Role: appContainerRole}, false, 0},
+		{ScanResult{Role: initContainerRole, Recognized: true, Issues: issues}, false, 1},
+		{ScanResult{Role: appContainerRole, Recognized: true, Issues: issues}, false, 2},
+		{ScanResult{Role: ephemeralContainerRole}, false, 0},
+		{ScanResult{Role: ephemeralContainerRole, Recognized: true, Issues: issues}, false, 1},
+	}
+	for _, tt := range tests {
+		got := pols.filterRole(tt.res)
+		if (got == nil) != tt.wantNil {
+			t.Errorf("filterRole(%+v) = %+v, want nil %v", tt.res, got, tt.wantNil)
+			continue
+		}
+		if got != nil && len(got.Issues) != tt.wantIssues {
+			t.Errorf("filterRole(%+v) kept %d issues, want %d", tt.res, len(got.Issues), tt.wantIssues)
+		}
+	}
+}
+
+func TestPolicyContainerRoles(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		wantInit      bool
+		wantEphemeral bool
+		wantErr       bool
+	}{
+		{"defaults", "deployments: ignore\nstatefulSets: ignore\n", true, true, false},
+		{"no init containers", "deployments: ignore\nstatefulSets: ignore\ninitContainers: false\n", false, true, false},
+		{"no ephemeral containers", "deployments: ignore\nstatefulSets: ignore\nephemeralContainers: false\n", true, false, false},
+		{"both", "deployments: ignore\nstatefulSets: ignore\ninitContainers: false\nephemeralContainers: false\n", false, false, false},
+		{"not a boolean", "deployments: ignore\nstatefulSets: ignore\nephemeralContainers: sometimes\n", false, false, true},
+	}
+	for _, tt := range tests {
+		var pol Policy
+		err := yaml.Unmarshal([]byte(tt.file), &pol)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := pol.checks(initContainerRole); got != tt.wantInit {
+			t.Errorf("%s: checks init containers = %v, want %v", tt.name, got, tt.wantInit)
+		}
+		if got := pol.checks(ephemeralContainerRole); got != tt.wantEphemeral {
+			t.Errorf("%s: checks ephemeral containers = %v, want %v", tt.name, got, tt.wantEphemeral)
+		}
+		if !pol.checks(appContainerRole) {
+			t.Errorf("%s: does not check app containers", tt.name)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// annotation listing the digests that caused a workload to be quarantined
+	quarantineAnnotation = "kubexray.io/quarantined-digests"
+	// annotation mapping those digests to their images, as digest=image
+	// pairs, so that exceptions for an image still apply when re-checking
+	quarantineImagesAnnotation = "kubexray.io/quarantined-images"
+	// annotation recording the replica count of a workload before it was scaled down
+	replicasAnnotation = "kubexray.io/original-replicas"
+	// node selector label used to keep a daemon set off every node
+	quarantineNodeLabel = "kubexray.io/quarantine"
+	// how often quarantined workloads are re-checked against xray
+	recoveryInterval = 5 * time.Minute
+)
+
+// RestoreRequest is the payload accepted by the restore API.
+type RestoreRequest struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+}
+
+// merge the digests of the given components into a quarantine annotation value
+func mergeDigests(existing string, comps []NotifyComponentPayload) string {
+	digests := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, sha2 := range strings.Split(existing, ",") {
+		if sha2 != "" && !seen[sha2] {
+			seen[sha2] = true
+			digests = append(digests, sha2)
+		}
+	}
+	for _, comp := range comps {
+		if comp.Checksum != "" && !seen[comp.Checksum] {
+			seen[comp.Checksum] = true
+			digests = append(digests, comp.Checksum)
+		}
+	}
+	return strings.Join(digests, ",")
+}
+
+// merge the images of the given components into a quarantine images
+// annotation value
+func mergeImages(existing string, comps []NotifyComponentPayload) string {
+	images := quarantinedImages(existing)
+	pairs := make([]string, 0)
+	for _, pair := range strings.Split(existing, ",") {
+		if pair != "" {
+			pairs = append(pairs, pair)
+		}
+	}
+	for _, comp := range comps {
+		if _, ok := images[comp.Checksum]; !ok && comp.Checksum != "" && comp.Name != "" {
+			images[comp.Checksum] = comp.Name
+			pairs = append(pairs, comp.Checksum+"="+comp.Name)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// read a quarantine images annotation value into a map from digest to image
+func quarantinedImages(val string) map[string]string {
+	images := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			images[parts[0]] = parts[1]
+		}
+	}
+	return images
+}
+
+// record the offending digests and the original replica count (if any) on a
+// workload that is about to be quarantined, keeping the count recorded by any
+// earlier quarantine
+func markQuarantined(meta *meta_v1.ObjectMeta, replicas *int32, comps []NotifyComponentPayload) {
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[quarantineAnnotation] = mergeDigests(meta.Annotations[quarantineAnnotation], comps)
+	meta.Annotations[quarantineImagesAnnotation] = mergeImages(meta.Annotations[quarantineImagesAnnotation], comps)
+	if _, ok := meta.Annotations[replicasAnnotation]; !ok && replicas != nil {
+		meta.Annotations[replicasAnnotation] = strconv.Itoa(int(*replicas))
+	}
+}
+
+// reset the replica count of a quarantined workload to the recorded value
+func restoreReplicas(meta *meta_v1.ObjectMeta, replicas *int32) error {
+	val, ok := meta.Annotations[replicasAnnotation]
+	if !ok {
+		return errors.New(meta.Name + " has no recorded replica count")
+	}
+	count, err := strconv.ParseInt(val, 10, 32)
+	if err != nil {
+		return err
+	}
+	*replicas = int32(count)
+	delete(meta.Annotations, replicasAnnotation)
+	delete(meta.Annotations, quarantineAnnotation)
+	delete(meta.Annotations, quarantineImagesAnnotation)
+	return nil
+}
+
+// unschedule a daemon set from every node by adding a node selector that no
+// node matches, recording the offending digests so it can be reverted later
+func quarantineDaemonSet(client kubernetes.Interface, owner Owner, comps []NotifyComponentPayload) error {
+	dsets := client.AppsV1().DaemonSets(owner.Namespace)
+	dset, err := dsets.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if dset.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	markQuarantined(&dset.ObjectMeta, nil, comps)
+	if dset.Spec.Template.Spec.NodeSelector == nil {
+		dset.Spec.Template.Spec.NodeSelector = make(map[string]string)
+	}
+	dset.Spec.Template.Spec.NodeSelector[quarantineNodeLabel] = "true"
+	_, err = dsets.Update(context.TODO(), dset, meta_v1.UpdateOptions{})
+	return err
+}
+
+// revert a quarantined daemon set so it is scheduled on its nodes again
+func restoreDaemonSet(client kubernetes.Interface, owner Owner) error {
+	dsets := client.AppsV1().DaemonSets(owner.Namespace)
+	dset, err := dsets.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if dset.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	delete(dset.Annotations, quarantineAnnotation)
+	delete(dset.Annotations, quarantineImagesAnnotation)
+	delete(dset.Spec.Template.Spec.NodeSelector, quarantineNodeLabel)
+	_, err = dsets.Update(context.TODO(), dset, meta_v1.UpdateOptions{})
+	return err
+}
+
+// scale a quarantined deployment back to its original replica count
+func restoreDeployment(client kubernetes.Interface, owner Owner) error {
+	deps := client.AppsV1().Deployments(owner.Namespace)
+	dep, err := deps.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if dep.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	err = restoreReplicas(&dep.ObjectMeta, dep.Spec.Replicas)
+	if err != nil {
+		return err
+	}
+	_, err = deps.Update(context.TODO(), dep, meta_v1.UpdateOptions{})
+	return err
+}
+
+// scale a quarantined stateful set back to its original replica count
+func restoreStatefulSet(client kubernetes.Interface, owner Owner) error {
+	sets := client.AppsV1().StatefulSets(owner.Namespace)
+	set, err := sets.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if set.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	err = restoreReplicas(&set.ObjectMeta, set.Spec.Replicas)
+	if err != nil {
+		return err
+	}
+	_, err = sets.Update(context.TODO(), set, meta_v1.UpdateOptions{})
+	return err
+}
+
+// scale a quarantined replica set back to its original replica count
+func restoreReplicaSet(client kubernetes.Interface, owner Owner) error {
+	rsets := client.AppsV1().ReplicaSets(owner.Namespace)
+	rset, err := rsets.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if rset.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	err = restoreReplicas(&rset.ObjectMeta, rset.Spec.Replicas)
+	if err != nil {
+		return err
+	}
+	_, err = rsets.Update(context.TODO(), rset, meta_v1.UpdateOptions{})
+	return err
+}
+
+// scale a quarantined replication controller back to its original replica count
+func restoreReplicationController(client kubernetes.Interface, owner Owner) error {
+	rcs := client.CoreV1().ReplicationControllers(owner.Namespace)
+	rc, err := rcs.Get(context.TODO(), owner.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if rc.UID != owner.UID {
+		return errors.New(owner.Name + " has been replaced")
+	}
+	err = restoreReplicas(&rc.ObjectMeta, rc.Spec.Replicas)
+	if err != nil {
+		return err
+	}
+	_, err = rcs.Update(context.TODO(), rc, meta_v1.UpdateOptions{})
+	return err
+}
+
+// revert the quarantine of a workload
+func restoreOwner(client kubernetes.Interface, owner Owner) error {
+	switch owner.Type {
+	case Deployment:
+		return restoreDeployment(client, owner)
+	case StatefulSet:
+		return restoreStatefulSet(client, owner)
+	case DaemonSet:
+		return restoreDaemonSet(client, owner)
+	case ReplicaSet:
+		return restoreReplicaSet(client, owner)
+	case ReplicationController:
+		return restoreReplicationController(client, owner)
+	}
+	return errors.New("cannot restore " + owner.Type.String())
+}
+
+// find every quarantined workload, along with its annotations recording the
+// digests that caused it
+func listQuarantined(client kubernetes.Interface) (map[Owner]map[string]string, error) {
+	result := make(map[Owner]map[string]string)
+	add := func(typ ResourceType, meta meta_v1.ObjectMeta) {
+		if _, ok := meta.Annotations[quarantineAnnotation]; ok {
+			result[Owner{Type: typ, Name: meta.Name, Namespace: meta.Namespace, UID: meta.UID}] = meta.Annotations
+		}
+	}
+	opts := meta_v1.ListOptions{}
+	deps, err := client.AppsV1().Deployments(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range deps.Items {
+		add(Deployment, dep.ObjectMeta)
+	}
+	sets, err := client.AppsV1().StatefulSets(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range sets.Items {
+		add(StatefulSet, set.ObjectMeta)
+	}
+	dsets, err := client.AppsV1().DaemonSets(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, dset := range dsets.Items {
+		add(DaemonSet, dset.ObjectMeta)
+	}
+	rsets, err := client.AppsV1().ReplicaSets(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, rset := range rsets.Items {
+		add(ReplicaSet, rset.ObjectMeta)
+	}
+	rcs, err := client.CoreV1().ReplicationControllers(meta_v1.NamespaceAll).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, rc := range rcs.Items {
+		add(ReplicationController, rc.ObjectMeta)
+	}
+	return result, nil
+}
+
+// setup the periodic check that reverts quarantined workloads once xray no
+// longer reports the offending digests as violations
+func setupRecovery(t *HandlerImpl, client kubernetes.Interface) {
+	go func() {
+		for range time.Tick(recoveryInterval) {
+			// bound each pass by the interval, so that passes never overlap
+			ctx, cancel := context.WithTimeout(context.Background(), recoveryInterval)
+			recoverQuarantined(ctx, t.snapshot(), client)
+			cancel()
+		}
+	}()
+}
+
+// check whether the policies still require action against the digests a
+// workload was acted upon for, given as the values of the annotations
+// recording the digests and their images
+func isStillViolating(ctx context.Context, t *HandlerImpl, client kubernetes.Interface, pols Policies, owner Owner, digests, imageList string) bool {
+	if t.url == "" {
+		return true
+	}
+	images := quarantinedImages(imageList)
+	shas := make([]string, 0)
+	for _, sha2 := range strings.Split(digests, ",") {
+		if sha2 != "" {
+			shas = append(shas, sha2)
+		}
+	}
+	batch := t.prefetchDigests(ctx, shas)
+	for _, sha2 := range shas {
+		res, err := t.checkDigest(ctx, pols, batch, sha2)
+		if err != nil {
+			// keep the workload quarantined until xray can confirm it is clean
+			return true
+		}
+		res.Issues = t.filterExceptions(res.Issues, owner.Namespace, images[sha2])
+		seciss, liciss := res.flags()
+		if isWhitelistedNamespace(pols, owner.Namespace, res.Recognized, seciss, liciss) {
+			continue
+		}
+		if t.decide(client, pols, nil, owner, PodScan{Results: []ScanResult{res}}) != Ignore {
+			return true
+		}
+	}
+	return false
+}
+
+// re-check every quarantined workload and revert those that are now clean
+func recoverQuarantined(ctx context.Context, t *HandlerImpl, client kubernetes.Interface) {
+	log.Debug("Checking quarantined workloads for recovery")
+	quarantined, err := listQuarantined(client)
+	if err != nil {
+		log.Warnf("Cannot list quarantined workloads: %s", err)
+		return
+	}
+	for owner, annotations := range quarantined {
+		pols := applyOverrides(client, t.policiesFor(client, owner.Namespace), nil, owner)
+		if isStillViolating(ctx, t, client, pols, owner, annotations[quarantineAnnotation], annotations[quarantineImagesAnnotation]) {
+			continue
+		}
+		if t.dryRun {
+			log.Infof("Dry run: would restore recovered %s %s in %s", owner.Type, owner.Name, owner.Namespace)
+			continue
+		}
+		log.Infof("Restoring recovered %s: %s", owner.Type, owner.Name)
+		err := restoreOwner(client, owner)
+		if err != nil {
+			log.Warnf("Cannot restore %s: %s", owner.Type, err)
+		}
+	}
+	recoverIsolated(ctx, t, client)
+	recoverLabelled(ctx, t, client)
+}
+
+// handle explicit requests to restore a quarantined workload
+func handleRestore(t *HandlerImpl, client kubernetes.Interface) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		log.Debug("Restore API triggered")
+		t := t.snapshot()
+		if req.Method != http.MethodPost {
+			resp.WriteHeader(405)
+			return
+		}
+		if !t.authorized(req) {
+			log.Warn("Restore request did not send an appropriate token, aborting")
+			resp.WriteHeader(403)
+			return
+		}
+		var data RestoreRequest
+		err := json.NewDecoder(req.Body).Decode(&data)
+		if err != nil {
+			log.Errorf("Error reading restore request: %v", err)
+			resp.WriteHeader(400)
+			return
+		}
+		obj, typ, err := getObject(client, data.Namespace, data.Kind, data.Name)
+		if err != nil {
+			log.Warnf("Cannot find %s %s to restore: %v", data.Kind, data.Name, err)
+			resp.WriteHeader(404)
+			return
+		}
+		owner := Owner{Type: typ, Name: obj.GetName(), Namespace: obj.GetNamespace(), UID: obj.GetUID()}
+		isolated, err := unisolateOwner(client, owner)
+		if err != nil {
+			log.Errorf("Cannot lift network isolation of %s: %v", owner.Type, err)
+			resp.WriteHeader(500)
+			return
+		}
+		if isolated {
+			log.Infof("Lifted network isolation of %s on request: %s", owner.Type, owner.Name)
+		}
+		if _, ok := obj.GetAnnotations()[quarantineAnnotation]; !ok {
+			if !isolated {
+				log.Warnf("Cannot restore %s %s: it is not quarantined", data.Kind, data.Name)
+				resp.WriteHeader(409)
+				return
+			}
+			resp.WriteHeader(200)
+			return
+		}
+		log.Infof("Restoring %s on request: %s", owner.Type, owner.Name)
+		err = restoreOwner(client, owner)
+		if err != nil {
+			log.Errorf("Cannot restore %s: %v", owner.Type, err)
+			resp.WriteHeader(500)
+			return
+		}
+		resp.WriteHeader(200)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfrog/kubexray/xray"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// a deployment in the default namespace with the given replica count and
+// annotations
+func testDeployment(name string, replicas int32, annotations map[string]string) *apps_v1.Deployment {
+	return &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default", UID: "dep-uid", Annotations: annotations},
+		Spec:       apps_v1.DeploymentSpec{Replicas: &replicas},
+	}
+}
+
+func getDeployment(t *testing.T, client kubernetes.Interface, name string) *apps_v1.Deployment {
+	dep, err := client.AppsV1().Deployments("default").Get(context.TODO(), name, meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get deployment %s: %v", name, err)
+	}
+	return dep
+}
+
+// a quarantined deployment, scaled down from three replicas
+func quarantinedDeployment(name string) *apps_v1.Deployment {
+	return testDeployment(name, 0, map[string]string{quarantineAnnotation: "abc", replicasAnnotation: "3"})
+}
+
+func TestScaledownRestore(t *testing.T) {
+	client := fake.NewSimpleClientset(testDeployment("web", 3, nil))
+	owner := Owner{Type: Deployment, Name: "web", Namespace: "default", UID: "dep-uid"}
+	pod := &core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Name: "web-5d4f-x7k2p", Namespace: "default"}}
+	removePod(client, pod, owner, Scaledown, Violation{}, []NotifyComponentPayload{{Name: "web:1", Checksum: "abc"}})
+	dep := getDeployment(t, client, "web")
+	if *dep.Spec.Replicas != 0 || dep.Annotations[quarantineAnnotation] != "abc" || dep.Annotations[quarantineImagesAnnotation] != "abc=web:1" || dep.Annotations[replicasAnnotation] != "3" {
+		t.Fatalf("after scaledown: replicas %d, annotations %v", *dep.Spec.Replicas, dep.Annotations)
+	}
+	// a second quarantine records the new digest but keeps the original count
+	removePod(client, pod, owner, Scaledown, Violation{}, []NotifyComponentPayload{{Name: "web:2", Checksum: "def"}})
+	dep = getDeployment(t, client, "web")
+	if dep.Annotations[quarantineAnnotation] != "abc,def" || dep.Annotations[quarantineImagesAnnotation] != "abc=web:1,def=web:2" || dep.Annotations[replicasAnnotation] != "3" {
+		t.Fatalf("after second scaledown: annotations %v", dep.Annotations)
+	}
+	if err := restoreOwner(client, owner); err != nil {
+		t.Fatalf("restoreOwner: %v", err)
+	}
+	dep = getDeployment(t, client, "web")
+	if *dep.Spec.Replicas != 3 || len(dep.Annotations) != 0 {
+		t.Errorf("after restore: replicas %d, annotations %v", *dep.Spec.Replicas, dep.Annotations)
+	}
+	// a replaced deployment is left alone
+	stale := Owner{Type: Deployment, Name: "web", Namespace: "default", UID: "old-uid"}
+	if err := restoreOwner(client, stale); err == nil {
+		t.Error("restoreOwner restored a replaced deployment")
+	}
+}
+
+func TestRecoverQuarantined(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	exception := func(image string) []Exception {
+		return []Exception{{ID: "CVE-2020-1234", Images: []string{image}, expiry: future}}
+	}
+	tests := []struct {
+		name         string
+		status       int
+		recognized   bool
+		unscanned    Action
+		exceptions   []Exception
+		wantReplicas int32
+	}{
+		{"clean", http.StatusOK, false, Ignore, nil, 3},
+		{"unrecognized", http.StatusOK, false, Scaledown, nil, 0},
+		{"still violating", http.StatusOK, true, Ignore, nil, 0},
+		{"excepted for the image", http.StatusOK, true, Ignore, exception("web:*"), 3},
+		{"excepted for another image", http.StatusOK, true, Ignore, exception("api:*"), 0},
+		{"xray unavailable", http.StatusInternalServerError, false, Ignore, nil, 0},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.WriteHeader(tt.status)
+			if !tt.recognized {
+				resp.Write([]byte(`{"sha256": "abc", "ids": []}`))
+			} else if strings.HasPrefix(req.URL.Path, "/api/v1/componentIdsByChecksum/") {
+				resp.Write([]byte(`{"sha256": "abc", "ids": [{"package_id": "docker://web", "version": "1"}]}`))
+			} else {
+				resp.Write([]byte(`{"total_count": 1, "data": [{"type": "security", "severity": "High", "issue_id": "XRAY-1", "cves": [{"cve": "CVE-2020-1234"}]}]}`))
+			}
+		}))
+		dep := quarantinedDeployment("web")
+		dep.Annotations[quarantineImagesAnnotation] = "abc=web:1"
+		client := fake.NewSimpleClientset(dep)
+		handler := &HandlerImpl{
+			url:        srv.URL,
+			xray:       xray.NewClient(srv.URL, "", "", xray.Options{}),
+			cache:      newScanCache(CacheOptions{}),
+			unscanned:  Policy{deployments: tt.unscanned},
+			security:   Policy{deployments: Scaledown},
+			exceptions: tt.exceptions,
+		}
+		recoverQuarantined(context.Background(), handler, client)
+		srv.Close()
+		if got := *getDeployment(t, client, "web").Spec.Replicas; got != tt.wantReplicas {
+			t.Errorf("%s: %d replicas after recovery, want %d", tt.name, got, tt.wantReplicas)
+		}
+	}
+}
+
+func TestHandleRestore(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		token        string
+		body         string
+		wantCode     int
+		wantReplicas int32
+	}{
+		{"wrong method", http.MethodGet, "secret", `{"namespace": "default", "kind": "Deployment", "name": "web"}`, 405, 0},
+		{"missing token", http.MethodPost, "", `{"namespace": "default", "kind": "Deployment", "name": "web"}`, 403, 0},
+		{"wrong token", http.MethodPost, "guess", `{"namespace": "default", "kind": "Deployment", "name": "web"}`, 403, 0},
+		{"malformed request", http.MethodPost, "secret", `{"namespace":`, 400, 0},
+		{"unknown workload", http.MethodPost, "secret", `{"namespace": "default", "kind": "Deployment", "name": "api"}`, 404, 0},
+		{"not quarantined", http.MethodPost, "secret", `{"namespace": "default", "kind": "Deployment", "name": "db"}`, 409, 0},
+		{"restored", http.MethodPost, "secret", `{"namespace": "default", "kind": "Deployment", "name": "web"}`, 200, 3},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(quarantinedDeployment("web"), testDeployment("db", 1, nil))
+		handler := &HandlerImpl{webhookToken: "secret"}
+		req := httptest.NewRequest(tt.method, "/restore", strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("X-Auth-Token", tt.token)
+		}
+		resp := httptest.NewRecorder()
+		handleRestore(handler, client)(resp, req)
+		if resp.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.Code, tt.wantCode)
+		}
+		if got := *getDeployment(t, client, "web").Spec.Replicas; got != tt.wantReplicas {
+			t.Errorf("%s: %d replicas, want %d", tt.name, got, tt.wantReplicas)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// the registry images without a registry host are pulled from
+	dockerHubRegistry = "registry-1.docker.io"
+	// how long to wait for a registry to respond
+	registryTimeout = 10 * time.Second
+)
+
+// the manifest types to ask the registry for, so that multi-arch images
+// resolve to the digest of their manifest list as when pulled by tag
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// the parameters of a WWW-Authenticate challenge
+var challengeParams = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// ImageRef is a parsed image reference.
+type ImageRef struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parse an image reference, resolving the defaults docker applies
+func parseImageRef(image string) ImageRef {
+	ref := ImageRef{Registry: dockerHubRegistry}
+	name := image
+	if idx := strings.Index(name, "@"); idx != -1 {
+		ref.Digest = name[idx+1:]
+		name = name[:idx]
+	}
+	// a tag follows the last colon, unless that colon is part of the host
+	if idx := strings.LastIndex(name, ":"); idx != -1 && !strings.Contains(name[idx:], "/") {
+		ref.Tag = name[idx+1:]
+		name = name[:idx]
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry = parts[0]
+		name = parts[1]
+	}
+	if ref.Registry == "docker.io" || ref.Registry == "index.docker.io" {
+		ref.Registry = dockerHubRegistry
+	}
+	if ref.Registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.Repository = name
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref
+}
+
+// RegistryAuth is the credentials for a registry, from an image pull secret.
+type RegistryAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// get the registry host a docker config entry applies to
+func registryHost(key string) string {
+	if u, err := url.Parse(key); err == nil && u.Host != "" {
+		key = u.Host
+	}
+	key = strings.SplitN(key, "/", 2)[0]
+	if key == "docker.io" || key == "index.docker.io" {
+		return dockerHubRegistry
+	}
+	return key
+}
+
+// read the registry credentials of the image pull secrets of a pod
+func registryCredentials(ctx context.Context, client kubernetes.Interface, pod *core_v1.Pod) map[string]RegistryAuth {
+	creds := make(map[string]RegistryAuth)
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		secret, err := client.CoreV1().Secrets(pod.Namespace).Get(ctx, ref.Name, meta_v1.GetOptions{})
+		if err != nil {
+			log.Debugf("Cannot read image pull secret %s: %s", ref.Name, err)
+			continue
+		}
+		var auths map[string]RegistryAuth
+		if data, ok := secret.Data[core_v1.DockerConfigJsonKey]; ok {
+			var config struct {
+				Auths map[string]RegistryAuth `json:"auths"`
+			}
+			err = json.Unmarshal(data, &config)
+			auths = config.Auths
+		} else if data, ok := secret.Data[core_v1.DockerConfigKey]; ok {
+			err = json.Unmarshal(data, &auths)
+		}
+		if err != nil {
+			log.Debugf("Cannot read image pull secret %s: %s", ref.Name, err)
+			continue
+		}
+		for key, auth := range auths {
+			if auth.Username == "" && auth.Auth != "" {
+				if dec, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil {
+					parts := strings.SplitN(string(dec), ":", 2)
+					if len(parts) == 2 {
+						auth.Username, auth.Password = parts[0], parts[1]
+					}
+				}
+			}
+			creds[registryHost(key)] = auth
+		}
+	}
+	return creds
+}
+
+// get a bearer token for the given challenge from the registry's token service
+func registryToken(ctx context.Context, client *http.Client, challenge string, auth *RegistryAuth) (string, error) {
+	params := make(map[string]string)
+	for _, match := range challengeParams.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	realm, ok := params["realm"]
+	if !ok {
+		return "", errors.New("registry challenge has no realm")
+	}
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if val, ok := params[key]; ok {
+			query.Set(key, val)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", errors.New("registry token service responded with status: " + resp.Status)
+	}
+	var data struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return "", err
+	}
+	if data.Token == "" {
+		return data.AccessToken, nil
+	}
+	return data.Token, nil
+}
+
+// ask the registry for the digest of the manifest an image tag points to
+func resolveDigest(ctx context.Context, image string, creds map[string]RegistryAuth) (string, error) {
+	ref := parseImageRef(image)
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	client := &http.Client{Timeout: registryTimeout}
+	manifest := "https://" + ref.Registry + "/v2/" + ref.Repository + "/manifests/" + ref.Tag
+	var auth *RegistryAuth
+	if cred, ok := creds[ref.Registry]; ok {
+		auth = &cred
+	}
+	head := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", manifest, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+	resp, err := head("")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == 401 {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		authorization := ""
+		if strings.HasPrefix(strings.ToLower(challenge), "bearer") {
+			token, err := registryToken(ctx, client, challenge, auth)
+			if err != nil {
+				return "", err
+			}
+			authorization = "Bearer " + token
+		} else if auth != nil {
+			enc := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+			authorization = "Basic " + enc
+		}
+		resp, err = head(authorization)
+		if err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != 200 {
+		return "", errors.New("registry responded with status: " + resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", errors.New("registry did not report the digest of " + image)
+	}
+	return digest, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseImageRef(t *testing.T) {
+	digest := "sha256:" + testDigest
+	tests := []struct {
+		image string
+		want  ImageRef
+	}{
+		{"nginx", ImageRef{Registry: dockerHubRegistry, Repository: "library/nginx", Tag: "latest"}},
+		{"nginx:1.17", ImageRef{Registry: dockerHubRegistry, Repository: "library/nginx", Tag: "1.17"}},
+		{"bitnami/redis:6.0", ImageRef{Registry: dockerHubRegistry, Repository: "bitnami/redis", Tag: "6.0"}},
+		{"docker.io/library/nginx:1.17", ImageRef{Registry: dockerHubRegistry, Repository: "library/nginx", Tag: "1.17"}},
+		{"index.docker.io/nginx", ImageRef{Registry: dockerHubRegistry, Repository: "library/nginx", Tag: "latest"}},
+		{"gcr.io/project/app:v1", ImageRef{Registry: "gcr.io", Repository: "project/app", Tag: "v1"}},
+		{"localhost/app", ImageRef{Registry: "localhost", Repository: "app", Tag: "latest"}},
+		{"registry:5000/app", ImageRef{Registry: "registry:5000", Repository: "app", Tag: "latest"}},
+		{"registry:5000/team/app:v2", ImageRef{Registry: "registry:5000", Repository: "team/app", Tag: "v2"}},
+		{"nginx@" + digest, ImageRef{Registry: dockerHubRegistry, Repository: "library/nginx", Digest: digest}},
+		{"nginx:1.17@" + digest, ImageRef{Registry: dockerHubRegistry, Repository: "library/nginx", Tag: "1.17", Digest: digest}},
+		{"registry:5000/app@" + digest, ImageRef{Registry: "registry:5000", Repository: "app", Digest: digest}},
+	}
+	for _, tt := range tests {
+		if got := parseImageRef(tt.image); got != tt.want {
+			t.Errorf("parseImageRef(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"https://index.docker.io/v1/", dockerHubRegistry},
+		{"docker.io", dockerHubRegistry},
+		{"gcr.io", "gcr.io"},
+		{"registry:5000/path", "registry:5000"},
+		{"https://registry.example.com:5000", "registry.example.com:5000"},
+	}
+	for _, tt := range tests {
+		if got := registryHost(tt.key); got != tt.want {
+			t.Errorf("registryHost(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+// an image pull secret in the default namespace holding the given docker
+// config
+func pullSecret(name, typ string, config interface{}) *core_v1.Secret {
+	data, _ := json.Marshal(config)
+	key := core_v1.DockerConfigJsonKey
+	if typ == string(core_v1.SecretTypeDockercfg) {
+		key = core_v1.DockerConfigKey
+	}
+	return &core_v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       core_v1.SecretType(typ),
+		Data:       map[string][]byte{key: data},
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:s3cret"))
+	client := fake.NewSimpleClientset(
+		pullSecret("hub", string(core_v1.SecretTypeDockerConfigJson), map[string]interface{}{
+			"auths": map[string]RegistryAuth{"https://index.docker.io/v1/": {Auth: auth}},
+		}),
+		pullSecret("legacy", string(core_v1.SecretTypeDockercfg), map[string]RegistryAuth{
+			"registry:5000": {Username: "ci", Password: "token"},
+		}),
+	)
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: core_v1.PodSpec{ImagePullSecrets: []core_v1.LocalObjectReference{
+			{Name: "hub"}, {Name: "legacy"}, {Name: "missing"},
+		}},
+	}
+	creds := registryCredentials(context.Background(), client, pod)
+	if len(creds) != 2 {
+		t.Fatalf("registryCredentials = %+v, want two registries", creds)
+	}
+	if got := creds[dockerHubRegistry]; got.Username != "robot" || got.Password != "s3cret" {
+		t.Errorf("docker hub credentials = %+v", got)
+	}
+	if got := creds["registry:5000"]; got.Username != "ci" || got.Password != "token" {
+		t.Errorf("registry:5000 credentials = %+v", got)
+	}
+}
+
+// serve a registry that hands out bearer tokens to robot:s3cret and knows
+// a single tag of team/app, making the default transport trust it
+func testRegistry(t *testing.T) string {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			if user, pass, ok := req.BasicAuth(); !ok || user != "robot" || pass != "s3cret" {
+				resp.WriteHeader(401)
+				return
+			}
+			resp.Write([]byte(`{"token": "t0k3n"}`))
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer t0k3n" {
+			resp.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry",scope="repository:team/app:pull"`)
+			resp.WriteHeader(401)
+			return
+		}
+		if req.URL.Path != "/v2/team/app/manifests/v1" || !strings.Contains(req.Header.Get("Accept"), manifestTypes[0]) {
+			resp.WriteHeader(404)
+			return
+		}
+		resp.Header().Set("Docker-Content-Digest", "sha256:"+testDigest)
+	}))
+	transport := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	t.Cleanup(func() {
+		http.DefaultTransport = transport
+		srv.Close()
+	})
+	return strings.TrimPrefix(srv.URL, "https://")
+}
+
+func TestResolveDigest(t *testing.T) {
+	host := testRegistry(t)
+	creds := map[string]RegistryAuth{host: {Username: "robot", Password: "s3cret"}}
+	tests := []struct {
+		name    string
+		image   string
+		creds   map[string]RegistryAuth
+		want    string
+		wantErr bool
+	}{
+		{"already pinned", "nginx@sha256:" + testDigest, nil, "sha256:" + testDigest, false},
+		{"authorized", host + "/team/app:v1", creds, "sha256:" + testDigest, false},
+		{"unknown tag", host + "/team/app:v2", creds, "", true},
+		{"no credentials", host + "/team/app:v1", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveDigest(context.Background(), tt.image, tt.creds)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: resolveDigest(%q) = %q, %v, want %q", tt.name, tt.image, got, err, tt.want)
+		}
+	}
+}
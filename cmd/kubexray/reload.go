@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// the locations of the config files, mounted from a secret and a config
+	// map, falling back to the working directory
+	xrayConfigPath     = "/config/secret/xray_config.yaml"
+	xrayConfigFallback = "./xray_config.yaml"
+	configPath         = "/config/conf/config.yaml"
+	configFallback     = "./config.yaml"
+	// how often to check the config files for changes
+	reloadInterval = 30 * time.Second
+)
+
+// get the top level keys whose values differ between two yaml documents
+func changedKeys(before, after []byte) []string {
+	var olddata, newdata map[string]interface{}
+	yaml.Unmarshal(before, &olddata)
+	yaml.Unmarshal(after, &newdata)
+	keys := make([]string, 0)
+	for key, val := range newdata {
+		if !reflect.DeepEqual(olddata[key], val) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range olddata {
+		if _, ok := newdata[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// record a kubernetes event against the kubexray pod, if it is known from the
+// POD_NAME and POD_NAMESPACE environment variables
+func recordEvent(client kubernetes.Interface, typ, reason, msg string) {
+	name, namespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return
+	}
+	now := meta_v1.Now()
+	event := &core_v1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			GenerateName: name + ".",
+			Namespace:    namespace,
+		},
+		InvolvedObject: core_v1.ObjectReference{Kind: "Pod", Name: name, Namespace: namespace},
+		Reason:         reason,
+		Message:        msg,
+		Type:           typ,
+		Source:         core_v1.EventSource{Component: "kubexray"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err := client.CoreV1().Events(namespace).Create(context.TODO(), event, meta_v1.CreateOptions{})
+	if err != nil {
+		log.Warnf("Cannot record event: %s", err)
+	}
+}
+
+// reload a config file if its contents changed since they were last seen,
+// keeping the current config if the new one is invalid
+func reloadFile(t *HandlerImpl, client kubernetes.Interface, name string, seen, file []byte, apply func([]byte) error) {
+	if bytes.Equal(seen, file) {
+		return
+	}
+	keys := changedKeys(seen, file)
+	t.mu.Lock()
+	err := apply(file)
+	t.mu.Unlock()
+	if err != nil {
+		log.Warnf("Keeping the current config, cannot read new %s: %s", name, err)
+		recordEvent(client, core_v1.EventTypeWarning, "ConfigInvalid", "Cannot read new "+name+": "+err.Error())
+		return
+	}
+	msg := "Reloaded " + name + ", changed: " + strings.Join(keys, ", ")
+	log.Info(msg)
+	recordEvent(client, core_v1.EventTypeNormal, "ConfigReloaded", msg)
+}
+
+// periodically check the config files for changes, such as updates to the
+// mounted config map and secret, and apply them without a restart
+func setupReload(t *HandlerImpl, client kubernetes.Interface) {
+	xrayseen, seen := t.xrayConfigData, t.configData
+	go func() {
+		for range time.Tick(reloadInterval) {
+			file, err := readConfigFile(xrayConfigPath, xrayConfigFallback)
+			if err == nil {
+				reloadFile(t, client, "xray_config.yaml", xrayseen, file, t.applyXrayConfig)
+				xrayseen = file
+			}
+			file, err = readConfigFile(configPath, configFallback)
+			if err == nil {
+				reloadFile(t, client, "config.yaml", seen, file, t.applyConfig)
+				seen = file
+			}
+			t.mu.Lock()
+			if t.webhookToken != "" && !t.webhookStarted {
+				setupXrayWebhook(t, client)
+			}
+			t.mu.Unlock()
+		}
+	}()
+}
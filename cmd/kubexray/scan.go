@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+)
+
+// Issue is a security or license issue reported by xray for an image.
+type Issue struct {
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	// the xray issue id or summary, or the name of the violated license
+	ID            string   `json:"issue_id"`
+	Summary       string   `json:"summary,omitempty"`
+	CVEs          []string `json:"cves,omitempty"`
+	Component     string   `json:"component,omitempty"`
+	FixedVersions []string `json:"fixed_versions,omitempty"`
+}
+
+// the issue reported for an image denied by the image policy
+var deniedIssue = Issue{Type: "security", Severity: Critical.String(), ID: "denied-image", Summary: "Image is denied by the image policy"}
+
+// check whether any of the given issues are security or license issues
+func issueFlags(issues []Issue) (bool, bool) {
+	seciss, liciss := false, false
+	for _, iss := range issues {
+		if iss.Type == "security" {
+			seciss = true
+		} else if iss.Type == "license" {
+			liciss = true
+		}
+	}
+	return seciss, liciss
+}
+
+// get the highest severity of the given issues
+func issueSeverity(issues []Issue) Severity {
+	sev := Unknown
+	for _, iss := range issues {
+		if s := parseSeverity(iss.Severity); s > sev {
+			sev = s
+		}
+	}
+	return sev
+}
+
+// ScanResult is the result of checking a single image with xray.
+type ScanResult struct {
+	Image      string  `json:"image"`
+	Checksum   string  `json:"checksum"`
+	Recognized bool    `json:"recognized"`
+	Issues     []Issue `json:"issues"`
+	// the role of the container running the image, if known
+	Role string `json:"role,omitempty"`
+}
+
+// check whether the image has any security or license issues
+func (r ScanResult) flags() (bool, bool) {
+	return issueFlags(r.Issues)
+}
+
+// keep the issues of the image at or above the given security and license
+// severities
+func (r ScanResult) atSeverity(secmin, licmin Severity) ScanResult {
+	issues := make([]Issue, 0)
+	for _, iss := range r.Issues {
+		min := licmin
+		if iss.Type == "security" {
+			min = secmin
+		}
+		if parseSeverity(iss.Severity) >= min {
+			issues = append(issues, iss)
+		}
+	}
+	r.Issues = issues
+	return r
+}
+
+// get the component of the image to report to xray and slack
+func (r ScanResult) component() NotifyComponentPayload {
+	return NotifyComponentPayload{Name: r.Image, Checksum: r.Checksum, Role: r.Role, Issues: r.Issues}
+}
+
+// PodScan is the aggregate of the scan results of every image in a pod.
+type PodScan struct {
+	Results []ScanResult
+}
+
+// check whether xray recognizes every image in the pod
+func (s PodScan) recognized() bool {
+	for _, res := range s.Results {
+		if !res.Recognized {
+			return false
+		}
+	}
+	return true
+}
+
+// get every issue of every image in the pod
+func (s PodScan) issues() []Issue {
+	issues := make([]Issue, 0)
+	for _, res := range s.Results {
+		issues = append(issues, res.Issues...)
+	}
+	return issues
+}
+
+// check whether any image in the pod has security or license issues
+func (s PodScan) flags() (bool, bool) {
+	return issueFlags(s.issues())
+}
+
+// get the highest severity of any issue in the pod
+func (s PodScan) severity() Severity {
+	return issueSeverity(s.issues())
+}
+
+// keep the issues of every image in the pod at or above the given security
+// and license severities
+func (s PodScan) atSeverity(secmin, licmin Severity) PodScan {
+	results := make([]ScanResult, 0, len(s.Results))
+	for _, res := range s.Results {
+		results = append(results, res.atSeverity(secmin, licmin))
+	}
+	return PodScan{Results: results}
+}
+
+// get the components of the pod to report to xray and slack
+func (s PodScan) components() []NotifyComponentPayload {
+	comps := make([]NotifyComponentPayload, 0)
+	for _, res := range s.Results {
+		if res.Checksum != "" {
+			comps = append(comps, res.component())
+		}
+	}
+	return comps
+}
+
+// get the violation of the pod, if any
+func (s PodScan) violation() Violation {
+	seciss, liciss := s.flags()
+	sev := ""
+	if s.severity() != Unknown {
+		sev = s.severity().String()
+	}
+	return getViolation(s.recognized(), seciss, liciss, sev)
+}
+
+// get a short description of the given issues, most severe first
+func describeIssues(issues []Issue) string {
+	ids := make([]string, 0)
+	for sev := int(Critical); sev >= int(Unknown); sev-- {
+		for _, iss := range issues {
+			if parseSeverity(iss.Severity) == Severity(sev) {
+				ids = append(ids, iss.ID)
+			}
+		}
+	}
+	return strings.Join(ids, ", ")
+}
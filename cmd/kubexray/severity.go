@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Severity represents the severity of an issue reported by xray, ordered from
+// least to most severe.
+type Severity byte
+
+const (
+	Unknown Severity = iota
+	Low
+	Medium
+	High
+	Critical
+)
+
+// String returns the name of the severity.
+func (s Severity) String() string {
+	switch s {
+	case Low:
+		return "Low"
+	case Medium:
+		return "Medium"
+	case High:
+		return "High"
+	case Critical:
+		return "Critical"
+	}
+	return "Unknown"
+}
+
+// get the severity of an issue as reported by xray, which uses both the
+// current (Low/Medium/High/Critical) and legacy (Minor/Major) names
+func parseSeverity(sev string) Severity {
+	switch strings.ToLower(strings.TrimSpace(sev)) {
+	case "low", "minor":
+		return Low
+	case "medium":
+		return Medium
+	case "high", "major":
+		return High
+	case "critical":
+		return Critical
+	}
+	return Unknown
+}
+
+// read the minimum severity configured in a policy, defaulting to high when
+// the key is missing
+func readSeverity(k map[string]interface{}, key string) (Severity, error) {
+	val, ok := k[key]
+	if !ok {
+		return High, nil
+	}
+	str, _ := val.(string)
+	sev := parseSeverity(str)
+	if sev == Unknown {
+		return High, errors.New("Cannot read severity with value '" + str + "'.")
+	}
+	return sev, nil
+}
+
+// get the minimum severity of issues this policy acts upon
+func (x Policy) threshold() Severity {
+	// policies missing from config.yaml keep the historical default
+	if x.minSeverity == Unknown {
+		return High
+	}
+	return x.minSeverity
+}
+
+// get the minimum severities of security and license issues to check
+// against the given policies; a policy expression sees every issue
+func (t *HandlerImpl) thresholds(pols Policies) (Severity, Severity) {
+	if t.expression != nil {
+		return Unknown, Unknown
+	}
+	return pols.security.threshold(), pols.license.threshold()
+}
+
+// get the lowest severity of issues of the given type that any of the
+// default or namespace policies act upon, or the policy expression sees
+func (t *HandlerImpl) minSeverity(isstype string) Severity {
+	if t.expression != nil {
+		return Unknown
+	}
+	sev := Policies{t.unscanned, t.security, t.license}.minSeverity(isstype)
+	for _, override := range t.namespaces {
+		pol := override.License
+		if isstype == "security" {
+			pol = override.Security
+		}
+		if pol != nil && pol.threshold() < sev {
+			sev = pol.threshold()
+		}
+	}
+	return sev
+}
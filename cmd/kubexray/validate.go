@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// configError is an error concerning a single key of the config file, which
+// is located by line once the whole file is known.
+type configError struct {
+	key   string
+	value string
+	msg   string
+	// the keys of the policy the key belongs to, if known
+	block []string
+}
+
+// Error returns the error message.
+func (e *configError) Error() string {
+	return e.key + ": " + e.msg
+}
+
+// get an error concerning the given key of a policy
+func keyError(k map[string]interface{}, key string, err error) error {
+	value := ""
+	if val, ok := k[key].(string); ok {
+		value = val
+	}
+	return inPolicy(k, &configError{key: key, value: value, msg: err.Error()})
+}
+
+// record the keys of the policy an error concerns, so that it is located in
+// that policy rather than wherever its key first occurs in the file
+func inPolicy(k map[string]interface{}, err error) error {
+	cerr, ok := err.(*configError)
+	if !ok {
+		return err
+	}
+	cerr.block = make([]string, 0, len(k))
+	for key := range k {
+		cerr.block = append(cerr.block, key)
+	}
+	return cerr
+}
+
+// get the edit distance between two strings, used to suggest the key a typo
+// was meant to be
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// check that a key is one of the known keys, suggesting the closest one if not
+func checkKey(key string, known []string) error {
+	best, dist := "", 3
+	for _, k := range known {
+		if k == key {
+			return nil
+		}
+		if d := editDistance(strings.ToLower(k), strings.ToLower(key)); d < dist {
+			best, dist = k, d
+		}
+	}
+	msg := "unknown key"
+	if best != "" {
+		msg += ", did you mean '" + best + "'?"
+	}
+	return &configError{key: key, msg: msg}
+}
+
+// yamlLine is a line of a yaml document holding a key.
+type yamlLine struct {
+	line   int
+	indent int
+	key    string
+	text   string
+	// whether the line starts a list item
+	item bool
+}
+
+// read the lines of a yaml document that hold a key, with the indentation
+// of the key
+func yamlKeys(file []byte) []yamlLine {
+	lines := make([]yamlLine, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(file))
+	for line := 1; scanner.Scan(); line++ {
+		raw := strings.TrimRight(scanner.Text(), " \t")
+		text := strings.TrimLeft(raw, " ")
+		item := strings.HasPrefix(text, "- ")
+		text = strings.TrimLeft(text, "- ")
+		idx := strings.Index(text, ":")
+		if text == "" || strings.HasPrefix(text, "#") || idx <= 0 {
+			continue
+		}
+		lines = append(lines, yamlLine{line: line, indent: len(raw) - len(text), key: text[:idx], text: text, item: item})
+	}
+	return lines
+}
+
+// get the keys of the mapping holding the i-th key line: the lines at the
+// same indentation up to the enclosing key or list item
+func yamlSiblings(lines []yamlLine, i int) map[string]bool {
+	keys := map[string]bool{lines[i].key: true}
+	for j := i - 1; j >= 0 && !lines[i].item && lines[j].indent >= lines[i].indent; j-- {
+		if lines[j].indent == lines[i].indent {
+			keys[lines[j].key] = true
+			if lines[j].item {
+				break
+			}
+		}
+	}
+	for j := i + 1; j < len(lines) && lines[j].indent >= lines[i].indent; j++ {
+		if lines[j].indent == lines[i].indent {
+			if lines[j].item {
+				break
+			}
+			keys[lines[j].key] = true
+		}
+	}
+	return keys
+}
+
+// find the line of the given key, and value if known, in a yaml document,
+// within the mapping made up of the given keys if known
+func findLine(file []byte, key, value string, block []string) int {
+	lines := yamlKeys(file)
+	for i, l := range lines {
+		if l.key != key {
+			continue
+		}
+		if value != "" && !strings.Contains(l.text[len(key)+1:], value) {
+			continue
+		}
+		if len(block) > 0 {
+			siblings := yamlSiblings(lines, i)
+			same := len(siblings) == len(block)
+			for _, k := range block {
+				same = same && siblings[k]
+			}
+			if !same {
+				continue
+			}
+		}
+		return l.line
+	}
+	return 0
+}
+
+// add the line number to an error concerning a single key of a config file
+func locateError(file []byte, err error) error {
+	cerr, ok := err.(*configError)
+	if !ok {
+		return err
+	}
+	line := findLine(file, cerr.key, cerr.value, cerr.block)
+	if line == 0 {
+		return err
+	}
+	return errors.New("line " + strconv.Itoa(line) + ": " + cerr.Error())
+}
+
+// check whether an invalid config.yaml should stop kubexray from starting,
+// which it does unless KUBE_XRAY_STRICT_CONFIG is false
+func strictConfig() bool {
+	val, ok := os.LookupEnv("KUBE_XRAY_STRICT_CONFIG")
+	if !ok {
+		return true
+	}
+	strict, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		log.Warnf("Unrecognized strict config value '%s'; expected true or false", val)
+		return true
+	}
+	return strict
+}
+
+// validate the config.yaml file at the given path, printing the outcome, and
+// return the exit code of the validate-config command
+func validateConfig(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: kubexray validate-config <file>")
+		return 2
+	}
+	file, err := ioutil.ReadFile(args[0])
+	if err == nil {
+		_, err = parseConfig(file)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err)
+		return 1
+	}
+	fmt.Printf("%s: valid\n", args[0])
+	return 0
+}
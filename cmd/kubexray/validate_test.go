@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"deployments", "deployments", 0},
+		{"deployments", "deploymets", 1},
+		{"deployments", "deploymentss", 1},
+		{"minseverity", "minsevertiy", 2},
+		{"pods", "jobs", 2},
+		{"", "pods", 4},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	known := []string{"deployments", "statefulSets", "minSeverity"}
+	tests := []struct {
+		key     string
+		wantErr bool
+		suggest string
+	}{
+		{"deployments", false, ""},
+		{"minSeverity", false, ""},
+		{"deploymets", true, "deployments"},
+		{"minseverity", true, "minSeverity"},
+		{"replicas", true, ""},
+	}
+	for _, tt := range tests {
+		err := checkKey(tt.key, known)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkKey(%q) error = %v, want error %v", tt.key, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		hint := strings.Contains(err.Error(), "did you mean")
+		if tt.suggest == "" && hint {
+			t.Errorf("checkKey(%q) = %q, want no suggestion", tt.key, err)
+		}
+		if tt.suggest != "" && !strings.Contains(err.Error(), "'"+tt.suggest+"'") {
+			t.Errorf("checkKey(%q) = %q, want suggestion %q", tt.key, err, tt.suggest)
+		}
+	}
+}
+
+func TestFindLine(t *testing.T) {
+	file := []byte(`unscanned:
+  deployments: ignore
+  statefulSets: ignore
+security:
+  deployments: scaledwon
+  statefulSets: ignore
+  minSeverity: High
+namespaces:
+  - namespace: dev
+    security:
+      deployments: delete
+      statefulSets: bogus
+  - namespace: prod
+    license:
+      deployments: ignore
+      statefulSets: bogus
+      # a comment
+`)
+	tests := []struct {
+		key   string
+		value string
+		block []string
+		want  int
+	}{
+		{"deployments", "", nil, 2},
+		{"deployments", "scaledwon", nil, 5},
+		{"deployments", "", []string{"deployments", "statefulSets", "minSeverity"}, 5},
+		{"statefulSets", "bogus", []string{"deployments", "statefulSets"}, 12},
+		{"namespace", "prod", nil, 13},
+		{"license", "", []string{"namespace", "license"}, 14},
+		{"statefulSets", "", []string{"statefulSets"}, 0},
+		{"replicas", "", nil, 0},
+	}
+	for _, tt := range tests {
+		if got := findLine(file, tt.key, tt.value, tt.block); got != tt.want {
+			t.Errorf("findLine(%q, %q, %v) = %d, want %d", tt.key, tt.value, tt.block, got, tt.want)
+		}
+	}
+}
+
+func TestParseConfigLocatesError(t *testing.T) {
+	file := []byte(`unscanned:
+  deployments: ignore
+  statefulSets: ignore
+security:
+  deployments: ignore
+  statefulSets: ignore
+license:
+  deployments: ignore
+  statefulSets: ignore
+namespaces:
+  - namespace: dev
+    license:
+      deployments: ignore
+      statefulSets: ignroe
+`)
+	_, err := parseConfig(file)
+	if err == nil {
+		t.Fatal("parseConfig accepted an unknown action")
+	}
+	if !strings.HasPrefix(err.Error(), "line 14: ") {
+		t.Errorf("parseConfig error = %q, want it located at line 14", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	invalid := filepath.Join(dir, "invalid.yaml")
+	missing := filepath.Join(dir, "missing.yaml")
+	if err := os.WriteFile(valid, []byte("unscanned:\n  deployments: ignore\n  statefulSets: ignore\nsecurity:\n  deployments: delete\n  statefulSets: ignore\nlicense:\n  deployments: ignore\n  statefulSets: ignore\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(invalid, []byte("security:\n  deployments: delte\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		path     string
+		strict   string
+		wantErr  bool
+		security Action
+	}{
+		{"valid", valid, "true", false, Delete},
+		{"missing", missing, "true", false, Ignore},
+		{"invalid", invalid, "true", true, Ignore},
+		{"invalid, not strict", invalid, "false", false, Ignore},
+	}
+	for _, tt := range tests {
+		t.Setenv("KUBE_XRAY_STRICT_CONFIG", tt.strict)
+		handler := &HandlerImpl{}
+		err := handler.loadConfig(tt.path, missing)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got := handler.security.action(Deployment); !tt.wantErr && got != tt.security {
+			t.Errorf("%s: security action = %s, want %s", tt.name, got, tt.security)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package xray
+
+import (
+	"context"
+	"net/url"
+)
+
+// Component is a component xray knows an image by, and the request payload
+// of the violations API.
+type Component struct {
+	Package string `json:"package_id"`
+	Version string `json:"version"`
+}
+
+// ComponentsResponse is the response from the component API.
+type ComponentsResponse struct {
+	Checksum   string      `json:"sha256"`
+	Components []Component `json:"ids"`
+}
+
+// Violation is an item of a ViolationsResponse.
+type Violation struct {
+	Type          string   `json:"type"`
+	Severity      string   `json:"severity"`
+	IssueID       string   `json:"issue_id"`
+	Summary       string   `json:"summary"`
+	CVEs          []CVE    `json:"cves"`
+	FixedVersions []string `json:"fixed_versions"`
+}
+
+// ViolationsResponse is the response from the violations API.
+type ViolationsResponse struct {
+	Total int         `json:"total_count"`
+	Data  []Violation `json:"data"`
+}
+
+// ArtifactSummaryRequest is the request payload of the artifact summary API.
+type ArtifactSummaryRequest struct {
+	Checksums []string `json:"checksums"`
+}
+
+// CVE is a cve of an ArtifactIssue or a Violation.
+type CVE struct {
+	CVE string `json:"cve"`
+}
+
+// IssueComponent is a component impacted by an ArtifactIssue.
+type IssueComponent struct {
+	ComponentID   string   `json:"component_id"`
+	FixedVersions []string `json:"fixed_versions"`
+}
+
+// ArtifactIssue is an issue of an Artifact.
+type ArtifactIssue struct {
+	IssueID    string           `json:"issue_id"`
+	Summary    string           `json:"summary"`
+	IssueType  string           `json:"issue_type"`
+	Severity   string           `json:"severity"`
+	CVEs       []CVE            `json:"cves"`
+	Components []IssueComponent `json:"components"`
+}
+
+// ArtifactGeneral is the general information of an Artifact.
+type ArtifactGeneral struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	PkgType     string `json:"pkg_type"`
+	Checksum    string `json:"sha256"`
+	ComponentID string `json:"component_id"`
+}
+
+// Artifact is an artifact of an ArtifactSummaryResponse.
+type Artifact struct {
+	General ArtifactGeneral `json:"general"`
+	Issues  []ArtifactIssue `json:"issues"`
+}
+
+// ArtifactSummaryResponse is the response from the artifact summary API.
+type ArtifactSummaryResponse struct {
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// ImpactedArtifact is an artifact impacted by a WebhookIssue.
+type ImpactedArtifact struct {
+	Name     string `json:"name"`
+	PkgType  string `json:"pkg_type"`
+	Checksum string `json:"sha256"`
+}
+
+// WebhookIssue is an issue of a WebhookPayload.
+type WebhookIssue struct {
+	Severity          string             `json:"severity"`
+	Type              string             `json:"type"`
+	Summary           string             `json:"summary"`
+	CVE               string             `json:"cve"`
+	ImpactedArtifacts []ImpactedArtifact `json:"impacted_artifacts"`
+}
+
+// WebhookPayload is the payload xray sends to the kubexray webhook when a
+// policy is violated.
+type WebhookPayload struct {
+	Issues []WebhookIssue `json:"issues"`
+}
+
+// ComponentsByChecksum gets the components xray knows an image checksum by.
+// Versions of xray without this API respond with an error satisfying
+// IsNotFound, in which case ArtifactSummary should be used instead.
+func (c *Client) ComponentsByChecksum(ctx context.Context, sha2 string) (*ComponentsResponse, error) {
+	var data ComponentsResponse
+	err := c.do(ctx, "GET", "/api/v1/componentIdsByChecksum/"+url.PathEscape(sha2), nil, &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// Violations gets the policy violations of a component, least severe first.
+func (c *Client) Violations(ctx context.Context, comp Component) (*ViolationsResponse, error) {
+	var data ViolationsResponse
+	path := "/ui/userIssues/details?direction=asc&order_by=severity&num_of_rows=0&page_num=0"
+	err := c.do(ctx, "POST", path, comp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// ArtifactSummary gets the issues of the artifacts with the given checksums.
+func (c *Client) ArtifactSummary(ctx context.Context, checksums []string) (*ArtifactSummaryResponse, error) {
+	var data ArtifactSummaryResponse
+	err := c.do(ctx, "POST", "/api/v1/summary/artifact", ArtifactSummaryRequest{Checksums: checksums}, &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// SendKubeMetadata reports the action kubexray took against a pod, encoded as
+// json from the given payload.
+func (c *Client) SendKubeMetadata(ctx context.Context, payload interface{}) error {
+	return c.do(ctx, "POST", "/api/v1/kube/metadata", payload, nil)
+}
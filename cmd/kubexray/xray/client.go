@@ -0,0 +1,174 @@
+// Package xray is a client for the parts of the JFrog Xray REST API that
+// kubexray uses, retrying requests that fail transiently.
+package xray
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout is how long a single request may take by default.
+	DefaultTimeout = 30 * time.Second
+	// DefaultRetries is how many times a failed request is retried by default.
+	DefaultRetries = 3
+	// DefaultBackoff is the delay before the first retry by default, doubled
+	// for each retry after it.
+	DefaultBackoff = 500 * time.Millisecond
+	// the longest delay between two attempts
+	maxBackoff = 30 * time.Second
+)
+
+// Options are the settings of a Client; zero values take the defaults.
+type Options struct {
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+}
+
+// Client is a client for the Xray REST API.
+type Client struct {
+	url     string
+	user    string
+	pass    string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// StatusError is the error returned when Xray responds with an unexpected
+// status code.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+// Error returns the error message.
+func (e *StatusError) Error() string {
+	return "xray server responded with status: " + e.Status
+}
+
+// IsNotFound checks whether an error is a 404 response from Xray, as returned
+// by older versions of Xray that lack an endpoint.
+func IsNotFound(err error) bool {
+	serr, ok := err.(*StatusError)
+	return ok && serr.Code == http.StatusNotFound
+}
+
+// NewClient creates a client for the Xray instance at the given url.
+func NewClient(url, user, pass string, opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	return &Client{
+		url:     strings.TrimSuffix(url, "/"),
+		user:    user,
+		pass:    pass,
+		http:    &http.Client{Timeout: opts.Timeout},
+		retries: opts.Retries,
+		backoff: opts.Backoff,
+	}
+}
+
+// check whether a response status is worth retrying
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// get how long to wait before the given retry, doubling with each retry and
+// jittered so that concurrent clients do not retry in lockstep, unless the
+// server asked for a specific delay; either is capped at maxBackoff
+func (c *Client) delay(retry int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			if d := time.Duration(secs) * time.Second; d < maxBackoff {
+				return d
+			}
+			return maxBackoff
+		}
+	}
+	d := c.backoff << uint(retry)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// send a request to xray, retrying on network errors and on 429 and 5xx
+// responses, and decode the json response into out, if given
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	for retry := 0; ; retry++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, c.url+path, reader)
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.SetBasicAuth(c.user, c.pass)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := c.http.Do(req)
+		if err == nil && !retryable(resp.StatusCode) {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+			}
+			if out == nil {
+				return nil
+			}
+			err = json.NewDecoder(resp.Body).Decode(out)
+			if err != nil {
+				return errors.New("cannot read xray response: " + err.Error())
+			}
+			return nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = &StatusError{Code: resp.StatusCode, Status: resp.Status}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if retry >= c.retries {
+			return err
+		}
+		d := c.delay(retry, resp)
+		// give up right away rather than retrying past the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return err
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package xray
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serve the components api, responding to each attempt with the next of the
+// given statuses and then with a component
+func testServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&attempts, 1)
+		if user, pass, ok := req.BasicAuth(); !ok || user != "admin" || pass != "password" {
+			t.Errorf("request without credentials")
+		}
+		if int(n) <= len(statuses) {
+			resp.WriteHeader(statuses[n-1])
+			return
+		}
+		resp.Write([]byte(`{"sha256": "abc", "ids": [{"package_id": "docker://web", "version": "1"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &attempts
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retries      int
+		wantErr      bool
+		wantNotFound bool
+		wantAttempts int32
+	}{
+		{"ok", nil, 3, false, false, 1},
+		{"rate limited", []int{429}, 3, false, false, 2},
+		{"server errors", []int{500, 502, 503}, 3, false, false, 4},
+		{"out of retries", []int{503, 503, 503}, 2, true, false, 3},
+		{"no retries", []int{500}, 0, true, false, 1},
+		{"not found", []int{404}, 3, true, true, 1},
+		{"unauthorized", []int{401}, 3, true, false, 1},
+	}
+	for _, tt := range tests {
+		srv, attempts := testServer(t, tt.statuses...)
+		client := NewClient(srv.URL+"/", "admin", "password", Options{Retries: tt.retries, Backoff: time.Millisecond})
+		res, err := client.ComponentsByChecksum(context.Background(), "abc")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if IsNotFound(err) != tt.wantNotFound {
+			t.Errorf("%s: IsNotFound(%v) = %v", tt.name, err, !tt.wantNotFound)
+		}
+		if err == nil && (res.Checksum != "abc" || len(res.Components) != 1) {
+			t.Errorf("%s: response = %+v", tt.name, res)
+		}
+		if got := atomic.LoadInt32(attempts); got != tt.wantAttempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, got, tt.wantAttempts)
+		}
+	}
+}
+
+func TestClientMalformedResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(`{"sha256": `))
+	}))
+	defer srv.Close()
+	client := NewClient(srv.URL, "admin", "password", Options{})
+	if _, err := client.ComponentsByChecksum(context.Background(), "abc"); err == nil {
+		t.Error("malformed response decoded without error")
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	failed := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(503)
+		failed <- struct{}{}
+	}))
+	defer srv.Close()
+	// a backoff long enough that only cancelling can end the wait
+	client := NewClient(srv.URL, "admin", "password", Options{Retries: 3, Backoff: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := client.ComponentsByChecksum(ctx, "abc")
+		done <- err
+	}()
+	<-failed
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not cancelled")
+	}
+	if len(failed) != 0 {
+		t.Error("request retried after being cancelled")
+	}
+}
+
+func TestClientDelay(t *testing.T) {
+	client := NewClient("http://xray", "admin", "password", Options{Backoff: time.Second})
+	tests := []struct {
+		retry      int
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{0, "", 500 * time.Millisecond, time.Second},
+		{2, "", 2 * time.Second, 4 * time.Second},
+		{20, "", maxBackoff / 2, maxBackoff},
+		{0, "2", 2 * time.Second, 2 * time.Second},
+		// the server cannot stall a worker for longer than maxBackoff
+		{0, "3600", maxBackoff, maxBackoff},
+		{0, "soon", 500 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.retryAfter != "" {
+			resp.Header.Set("Retry-After", tt.retryAfter)
+		}
+		if got := client.delay(tt.retry, resp); got < tt.min || got > tt.max {
+			t.Errorf("delay(%d, %q) = %s, want between %s and %s", tt.retry, tt.retryAfter, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestClientDeadline(t *testing.T) {
+	srv, attempts := testServer(t, 503, 503)
+	client := NewClient(srv.URL, "admin", "password", Options{Retries: 3, Backoff: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// the retry would only happen after the deadline, so there is none
+	_, err := client.ComponentsByChecksum(ctx, "abc")
+	if err == nil || atomic.LoadInt32(attempts) != 1 {
+		t.Errorf("error = %v after %d attempts, want an error after 1", err, atomic.LoadInt32(attempts))
+	}
+}